@@ -13,6 +13,8 @@ import (
 	"web-server/internal/logger"
 	"web-server/internal/repository"
 	"web-server/internal/service"
+	"web-server/internal/storage"
+	"web-server/internal/util"
 
 	"github.com/gorilla/mux"
 )
@@ -26,6 +28,13 @@ func main() {
 
 	log := logger.New(cfg)
 
+	switch cfg.Security.TokenMode {
+	case "", "session", "jwt":
+	default:
+		log.Error("unknown security.token_mode", "mode", cfg.Security.TokenMode)
+		os.Exit(1)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	pg, err := db.New(ctx, cfg.Postgres.DSN)
@@ -37,13 +46,128 @@ func main() {
 
 	rdb := cache.New(cfg)
 
+	blobs, err := storage.New(cfg)
+	if err != nil {
+		log.Error("storage init", "err", err)
+		os.Exit(1)
+	}
+
 	repo := repository.NewRepository(pg)
-	userSvc := service.NewUserService(repo)
+	sessionOpts := service.SessionOptions{
+		TTL:                time.Duration(cfg.Security.TokenTTLSeconds) * time.Second,
+		RefreshTTL:         time.Duration(cfg.Security.RefreshTTLHours) * time.Hour,
+		Sliding:            cfg.Security.SlidingExpiry,
+		CacheTTL:           time.Duration(cfg.Security.SessionCacheSeconds) * time.Second,
+		RevocationCacheTTL: time.Duration(cfg.Security.RevocationCacheSeconds) * time.Second,
+	}
+	if cfg.Security.TokenMode == "jwt" {
+		var keys []util.SigningKey
+		for _, k := range cfg.Security.SigningKeys {
+			key, err := util.ParseSigningKey(k.KID, k.Alg, k.Key, k.PublicKey)
+			if err != nil {
+				log.Error("signing keys", "err", err)
+				os.Exit(1)
+			}
+			keys = append(keys, key)
+		}
+		sessionOpts.Signer, err = util.NewSigner(keys, cfg.Security.SigningKID)
+		if err != nil {
+			log.Error("signing keys", "err", err)
+			os.Exit(1)
+		}
+	}
+	userSvc := service.NewUserService(repo, repository.NewAPIKeyRepository(pg), rdb, sessionOpts)
+	if sessionOpts.Signer != nil {
+		if err := userSvc.SyncRevocations(ctx); err != nil {
+			log.Error("load revoked tokens", "err", err)
+		}
+		syncEvery := time.Duration(cfg.Security.RevocationSyncSeconds) * time.Second
+		if syncEvery <= 0 {
+			syncEvery = 30 * time.Second
+		}
+		go func() {
+			for range time.Tick(syncEvery) {
+				if err := userSvc.SyncRevocations(context.Background()); err != nil {
+					log.Error("sync revoked tokens", "err", err)
+				}
+			}
+		}()
+	}
 	uh := handler.NewUserHandler(log, cfg, userSvc)
 
-	docRepo := repository.NewDocumentRepository(pg)
-	docSvc := service.NewDocumentService(docRepo, rdb, time.Duration(cfg.Security.TokenTTLSeconds)*time.Millisecond, "uploads")
-	docH := handler.NewDocumentHandler(docSvc, userSvc)
+	maxRevisions := cfg.Documents.MaxRevisions
+	if maxRevisions <= 0 {
+		maxRevisions = 20
+	}
+	docRepo := repository.NewDocumentRepository(pg, maxRevisions)
+	listTTL := time.Duration(cfg.Documents.ListCacheSeconds) * time.Second
+	if listTTL <= 0 {
+		listTTL = time.Minute
+	}
+	groupRepo := repository.NewGroupRepository(pg)
+	docSvc := service.NewDocumentService(docRepo, groupRepo, rdb, listTTL, blobs)
+	docH := handler.NewDocumentHandler(docSvc, userSvc, cfg.Uploads)
+
+	shareTTL := time.Duration(cfg.Documents.ShareLinkTTLHours) * time.Hour
+	if shareTTL <= 0 {
+		shareTTL = 7 * 24 * time.Hour
+	}
+	shareSvc := service.NewShareService(repository.NewShareRepository(pg), docRepo, blobs, shareTTL)
+	shareH := handler.NewShareHandler(shareSvc, userSvc)
+	transferSvc := service.NewTransferService(repository.NewTransferRepository(pg), docRepo, rdb)
+	transferH := handler.NewTransferHandler(transferSvc, userSvc, cfg.Server.AdminToken)
+	groupH := handler.NewGroupHandler(service.NewGroupService(groupRepo, rdb), userSvc)
+
+	storageGC := service.NewStorageGC(docRepo, blobs)
+	adminH := handler.NewAdminHandler(log, cfg, storageGC)
+	if cfg.Storage.GCIntervalMinutes > 0 {
+		go func() {
+			for range time.Tick(time.Duration(cfg.Storage.GCIntervalMinutes) * time.Minute) {
+				report, err := storageGC.Sweep(context.Background(), cfg.Storage.GCRemove)
+				if err != nil {
+					log.Error("storage gc", "err", err)
+					continue
+				}
+				if len(report.Orphans) > 0 || len(report.Missing) > 0 || report.Reaped > 0 {
+					log.Warn("storage gc", "orphans", report.Orphans, "removed", report.Removed, "missing", report.Missing, "reaped", report.Reaped)
+				}
+			}
+		}()
+	}
+
+	if days := cfg.Documents.TrashRetentionDays; days > 0 {
+		go func() {
+			for range time.Tick(time.Hour) {
+				n, err := docSvc.PurgeTrash(context.Background(), time.Now().AddDate(0, 0, -days))
+				if err != nil {
+					log.Error("purge trash", "err", err)
+					continue
+				}
+				if n > 0 {
+					log.Info("purged trash", "count", n)
+				}
+			}
+		}()
+	}
+
+	tusExpiry := time.Duration(cfg.Uploads.TusExpirationHours) * time.Hour
+	if tusExpiry <= 0 {
+		tusExpiry = 24 * time.Hour
+	}
+	uploadSvc := service.NewUploadService(repository.NewUploadRepository(pg), blobs, docSvc, tusExpiry)
+	tusH := handler.NewTusHandler(uploadSvc, userSvc, cfg.Uploads)
+	go func() {
+		for range time.Tick(time.Hour) {
+			n, err := uploadSvc.PurgeExpired(context.Background())
+			if err != nil {
+				log.Error("purge expired uploads", "err", err)
+				continue
+			}
+			if n > 0 {
+				log.Info("purged expired uploads", "count", n)
+			}
+		}
+	}()
 
 	r := mux.NewRouter()
 	api := r.PathPrefix("/api").Subrouter()
@@ -52,11 +176,56 @@ func main() {
 	api.HandleFunc("/auth", uh.Auth).Methods("POST")
 
 	api.HandleFunc("/auth", uh.Logout).Methods("DELETE")
+	api.HandleFunc("/auth/refresh", uh.Refresh).Methods("POST")
+	api.HandleFunc("/sessions", uh.ListSessions).Methods("GET")
+	api.HandleFunc("/sessions", uh.RevokeOtherSessions).Methods("DELETE")
+	api.HandleFunc("/sessions/{id}", uh.RevokeSession).Methods("DELETE")
+	api.HandleFunc("/keys", uh.ListAPIKeys).Methods("GET")
+	api.HandleFunc("/keys", uh.CreateAPIKey).Methods("POST")
+	api.HandleFunc("/keys/{id}", uh.RevokeAPIKey).Methods("DELETE")
 
 	r.HandleFunc("/api/docs", docH.ListDocs).Methods(http.MethodGet, http.MethodHead)
 	r.HandleFunc("/api/docs", docH.UploadDoc).Methods(http.MethodPost)
 	r.HandleFunc("/api/docs/{id}", docH.GetDoc).Methods(http.MethodGet, http.MethodHead)
 	r.HandleFunc("/api/docs/{id}", docH.DeleteDoc).Methods(http.MethodDelete)
+	r.HandleFunc("/api/docs/{id}", docH.PutDoc).Methods(http.MethodPut)
+	r.HandleFunc("/api/docs/{id}", docH.PatchDoc).Methods(http.MethodPatch)
+	r.HandleFunc("/api/docs/{id}/grants", docH.ListGrants).Methods(http.MethodGet)
+	r.HandleFunc("/api/docs/{id}/grants", docH.AddGrant).Methods(http.MethodPost)
+	r.HandleFunc("/api/docs/{id}/grants/{login}", docH.RevokeGrant).Methods(http.MethodDelete)
+	r.HandleFunc("/api/docs/{id}/grants/groups/{group}", docH.RevokeGrant).Methods(http.MethodDelete)
+	r.HandleFunc("/api/docs/{id}/transfer", transferH.TransferDoc).Methods(http.MethodPost)
+	r.HandleFunc("/api/docs/{id}/links", shareH.ListLinks).Methods(http.MethodGet)
+	r.HandleFunc("/api/docs/{id}/links", shareH.CreateLink).Methods(http.MethodPost)
+	r.HandleFunc("/api/docs/{id}/links/{link}", shareH.RevokeLink).Methods(http.MethodDelete)
+	r.HandleFunc("/api/share/{token}", shareH.Download).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/docs/{id}/revisions", docH.ListRevisions).Methods(http.MethodGet)
+	r.HandleFunc("/api/docs/{id}/revisions/diff", docH.DiffRevisions).Methods(http.MethodGet)
+	r.HandleFunc("/api/docs/{id}/revisions/{version:[0-9]+}", docH.GetRevision).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/docs/{id}/revisions/{version:[0-9]+}/restore", docH.RestoreRevision).Methods(http.MethodPost)
+
+	r.HandleFunc("/api/groups", groupH.List).Methods(http.MethodGet)
+	r.HandleFunc("/api/groups", groupH.Create).Methods(http.MethodPost)
+	r.HandleFunc("/api/groups/{name}", groupH.Get).Methods(http.MethodGet)
+	r.HandleFunc("/api/groups/{name}", groupH.Delete).Methods(http.MethodDelete)
+	r.HandleFunc("/api/groups/{name}/members", groupH.AddMember).Methods(http.MethodPost)
+	r.HandleFunc("/api/groups/{name}/members/{login}", groupH.RemoveMember).Methods(http.MethodDelete)
+
+	r.HandleFunc("/api/transfers", transferH.ListTransfers).Methods(http.MethodGet)
+	r.HandleFunc("/api/transfers", transferH.TransferAll).Methods(http.MethodPost)
+
+	r.HandleFunc("/api/trash", docH.ListTrash).Methods(http.MethodGet)
+	r.HandleFunc("/api/trash", docH.EmptyTrash).Methods(http.MethodDelete)
+	r.HandleFunc("/api/trash/{id}", docH.PurgeTrash).Methods(http.MethodDelete)
+	r.HandleFunc("/api/trash/{id}/restore", docH.RestoreTrash).Methods(http.MethodPost)
+
+	r.HandleFunc("/api/admin/gc", adminH.StorageGC).Methods(http.MethodPost)
+
+	r.HandleFunc("/api/uploads", tusH.Options).Methods(http.MethodOptions)
+	r.HandleFunc("/api/uploads", tusH.Create).Methods(http.MethodPost)
+	r.HandleFunc("/api/uploads/{id}", tusH.Head).Methods(http.MethodHead)
+	r.HandleFunc("/api/uploads/{id}", tusH.Patch).Methods(http.MethodPatch)
+	r.HandleFunc("/api/uploads/{id}", tusH.Terminate).Methods(http.MethodDelete)
 
 	srv := &http.Server{
 		Addr:         cfg.Server.Addr,
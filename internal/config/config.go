@@ -19,14 +19,91 @@ type RedisCfg struct {
 	DB       int    `yaml:"db"`
 }
 type SecurityCfg struct {
+	// TokenTTLSeconds is the lifetime of access tokens.
 	TokenTTLSeconds int `yaml:"token_ttl_seconds"`
+	// RefreshTTLHours is how long a session can be kept alive with refresh
+	// tokens; 0 issues none.
+	RefreshTTLHours int `yaml:"refresh_ttl_hours"`
+	// SlidingExpiry renews access tokens while they are in use.
+	SlidingExpiry bool `yaml:"sliding_expiry"`
+	// TokenMode is "session" (default) for opaque tokens checked against
+	// Postgres, or "jwt" for signed tokens checked locally.
+	TokenMode string `yaml:"token_mode"`
+	// SigningKeys verify signed tokens; SigningKID names the one that signs.
+	SigningKeys []SigningKeyCfg `yaml:"signing_keys"`
+	SigningKID  string          `yaml:"signing_kid"`
+	// SessionCacheSeconds caps how long a validated session is kept in
+	// Redis; 0 disables the cache.
+	SessionCacheSeconds int `yaml:"session_cache_seconds"`
+	// RevocationCacheSeconds is how long a signed token found not revoked
+	// is trusted before Redis is asked again; 0 asks on every request.
+	RevocationCacheSeconds int `yaml:"revocation_cache_seconds"`
+	// RevocationSyncSeconds is how often the full list of revoked signed
+	// tokens is reloaded from Postgres.
+	RevocationSyncSeconds int `yaml:"revocation_sync_seconds"`
+}
+
+// SigningKeyCfg is a key for signed tokens. Key is the base64 HS256 secret
+// or EdDSA seed; an EdDSA key with only PublicKey verifies but never signs.
+type SigningKeyCfg struct {
+	KID       string `yaml:"kid"`
+	Alg       string `yaml:"alg"`
+	Key       string `yaml:"key"`
+	PublicKey string `yaml:"public_key"`
+}
+
+type S3Cfg struct {
+	Endpoint   string `yaml:"endpoint"`
+	Region     string `yaml:"region"`
+	Bucket     string `yaml:"bucket"`
+	Prefix     string `yaml:"prefix"`
+	AccessKey  string `yaml:"access_key"`
+	SecretKey  string `yaml:"secret_key"`
+	UseSSL     bool   `yaml:"use_ssl"`
+	PartSizeMB int    `yaml:"part_size_mb"`
+}
+type StorageCfg struct {
+	Driver            string `yaml:"driver"`
+	Dir               string `yaml:"dir"`
+	S3                S3Cfg  `yaml:"s3"`
+	GCIntervalMinutes int    `yaml:"gc_interval_minutes"`
+	GCRemove          bool   `yaml:"gc_remove"`
+}
+
+type UploadsCfg struct {
+	MaxSizeMB          int            `yaml:"max_size_mb"`
+	UserMaxSizeMB      map[string]int `yaml:"user_max_size_mb"`
+	TusExpirationHours int            `yaml:"tus_expiration_hours"`
+}
+
+// MaxSize returns the upload limit for login in bytes, 0 meaning unlimited.
+func (c UploadsCfg) MaxSize(login string) int64 {
+	if mb, ok := c.UserMaxSizeMB[login]; ok {
+		return int64(mb) << 20
+	}
+	return int64(c.MaxSizeMB) << 20
+}
+
+type DocumentsCfg struct {
+	MaxRevisions int `yaml:"max_revisions"`
+	// TrashRetentionDays is how long deleted documents stay restorable;
+	// 0 keeps them until purged by hand.
+	TrashRetentionDays int `yaml:"trash_retention_days"`
+	// ShareLinkTTLHours is the lifetime of share links created without an
+	// explicit expiry.
+	ShareLinkTTLHours int `yaml:"share_link_ttl_hours"`
+	// ListCacheSeconds is how long document lists are cached in Redis.
+	ListCacheSeconds int `yaml:"list_cache_seconds"`
 }
 
 type Config struct {
-	Server   ServerCfg   `yaml:"server"`
-	Postgres PostgresCfg `yaml:"postgres"`
-	Redis    RedisCfg    `yaml:"redis"`
-	Security SecurityCfg `yaml:"security"`
+	Server    ServerCfg    `yaml:"server"`
+	Postgres  PostgresCfg  `yaml:"postgres"`
+	Redis     RedisCfg     `yaml:"redis"`
+	Security  SecurityCfg  `yaml:"security"`
+	Storage   StorageCfg   `yaml:"storage"`
+	Uploads   UploadsCfg   `yaml:"uploads"`
+	Documents DocumentsCfg `yaml:"documents"`
 }
 
 func Load(path string) (*Config, error) {
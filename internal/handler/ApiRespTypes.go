@@ -1,9 +1,14 @@
 package handler
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
+	"web-server/internal/models"
+	"web-server/internal/service"
 )
 
 type Answer struct {
@@ -13,9 +18,46 @@ type Answer struct {
 	File    bool           `json:"file"`
 	Public  bool           `json:"public"`
 	Created string         `json:"created"`
-	Grant   []string       `json:"grant"`
+	Grant   []models.Grant `json:"grant"`
+	Version int64          `json:"version"`
+	ETag    string         `json:"etag"`
+	Deleted string         `json:"deleted,omitempty"`
 	Json    map[string]any `json:"json,omitempty"`
 }
+
+func toAnswer(doc *models.Document) Answer {
+	answer := Answer{
+		ID:      doc.ID,
+		Name:    doc.Name,
+		Mime:    doc.Mime,
+		File:    doc.File,
+		Public:  doc.Public,
+		Created: doc.CreatedAt.Format(time.DateTime),
+		Grant:   doc.Grants,
+		Version: doc.Version,
+		ETag:    documentETag(doc),
+	}
+	if doc.DeletedAt != nil {
+		answer.Deleted = doc.DeletedAt.Format(time.DateTime)
+	}
+	if len(doc.JSONRaw) > 0 {
+		_ = json.Unmarshal(doc.JSONRaw, &answer.Json)
+	}
+	return answer
+}
+
+// RevisionAnswer describes one entry of a document history.
+type RevisionAnswer struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Mime    string `json:"mime,omitempty"`
+	File    bool   `json:"file"`
+	Size    int64  `json:"size,omitempty"`
+	Updated string `json:"updated"`
+	ETag    string `json:"etag"`
+	Current bool   `json:"current"`
+}
+
 type APIError struct {
 	Code int    `json:"code,omitempty"`
 	Text string `json:"text,omitempty"`
@@ -46,3 +88,42 @@ func getTokenFromHeader(r *http.Request) string {
 	}
 	return ""
 }
+
+// isAdminToken reports whether token is the configured admin token. An
+// unset admin token matches nothing.
+func isAdminToken(token, adminToken string) bool {
+	if token == "" || adminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// anonymous is the requester of calls made without an Authorization
+// header; it only ever has access to public documents.
+const anonymous = ""
+
+// authorize resolves the session token or API key of a document request;
+// an API key must carry scope. ok is false once a 401 or 403 has been
+// written.
+func authorize(w http.ResponseWriter, r *http.Request, us service.UserService, scope string) (string, bool) {
+	login, err := us.Authorize(r.Context(), getTokenFromHeader(r), clientIP(r), scope)
+	switch {
+	case errors.Is(err, service.ErrScopeDenied), errors.Is(err, service.ErrIPNotAllowed):
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: err.Error()}})
+		return "", false
+	case err != nil:
+		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "unauthorized"}})
+		return "", false
+	}
+	return login, true
+}
+
+// optionalLogin is authorize for endpoints open to anonymous requests. A
+// request without an Authorization header is anonymous, but a bad token is
+// still refused.
+func optionalLogin(w http.ResponseWriter, r *http.Request, us service.UserService, scope string) (string, bool) {
+	if r.Header.Get("Authorization") == "" {
+		return anonymous, true
+	}
+	return authorize(w, r, us, scope)
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+	"web-server/internal/config"
+	"web-server/internal/logger"
+	"web-server/internal/service"
+)
+
+// AdminHandler serves maintenance endpoints authorized by the admin token
+// from the config, passed as a bearer token.
+type AdminHandler struct {
+	log *logger.Logger
+	cfg *config.Config
+	gc  service.StorageGC
+}
+
+func NewAdminHandler(log *logger.Logger, cfg *config.Config, gc service.StorageGC) *AdminHandler {
+	return &AdminHandler{log: log, cfg: cfg, gc: gc}
+}
+
+func (h *AdminHandler) authorized(r *http.Request) bool {
+	return isAdminToken(getTokenFromHeader(r), h.cfg.Server.AdminToken)
+}
+
+// POST /api/admin/gc?remove=true
+func (h *AdminHandler) StorageGC(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "not authorized"}})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+	report, err := h.gc.Sweep(ctx, r.URL.Query().Get("remove") == "true")
+	if err != nil {
+		h.log.Error("storage gc", "err", err)
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "gc failed"}})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Response: report})
+}
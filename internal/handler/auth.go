@@ -3,13 +3,31 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 	"time"
 	"web-server/internal/config"
 	"web-server/internal/logger"
+	"web-server/internal/models"
 	"web-server/internal/service"
+
+	"github.com/gorilla/mux"
 )
 
+// maxUserAgent bounds the user agent stored with a session.
+const maxUserAgent = 512
+
+// clientIP is the address of the peer the request came from. Forwarding
+// headers are not trusted.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 type UserHandler struct {
 	log     *logger.Logger
 	cfg     *config.Config
@@ -53,13 +71,43 @@ func (h *UserHandler) Auth(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	token, err := h.service.Auth(ctx, req.Login, req.Pswd,
-		time.Duration(h.cfg.Security.TokenTTLSeconds)*time.Second)
+	client := models.Session{UserAgent: r.UserAgent(), IP: clientIP(r)}
+	if len(client.UserAgent) > maxUserAgent {
+		client.UserAgent = client.UserAgent[:maxUserAgent]
+	}
+	tokens, err := h.service.Auth(ctx, req.Login, req.Pswd, client)
 	if err != nil {
 		writeJSON(w, r, 401, &APIResponse{Error: &APIError{Code: 401, Text: err.Error()}})
 		return
 	}
-	writeJSON(w, r, 200, &APIResponse{Response: map[string]string{"token": token}})
+	writeJSON(w, r, 200, &APIResponse{Response: tokens})
+}
+
+// POST /api/auth/refresh
+func (h *UserHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, r, 400, &APIResponse{Error: &APIError{Code: 400, Text: "invalid json"}})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	tokens, err := h.service.Refresh(ctx, req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, service.ErrRefreshTokenReused) {
+			h.log.Warn("refresh token reused, session revoked", "ip", clientIP(r))
+		}
+		if errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrRefreshTokenReused) {
+			writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: err.Error()}})
+			return
+		}
+		h.log.Error("refresh", "err", err)
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "failed to refresh"}})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Response: tokens})
 }
 
 // DELETE /api/auth
@@ -79,3 +127,125 @@ func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, r, http.StatusOK, &APIResponse{Response: map[string]bool{token: true}})
 }
+
+// GET /api/sessions
+func (h *UserHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	token := getTokenFromHeader(r)
+	login, err := h.service.ValidateToken(r.Context(), token)
+	if err != nil {
+		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "not authorized"}})
+		return
+	}
+	sessions, err := h.service.ListSessions(r.Context(), login, token)
+	if err != nil {
+		h.log.Error("list sessions", "err", err)
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "failed to list sessions"}})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Data: map[string]any{"sessions": sessions}})
+}
+
+// DELETE /api/sessions/{id}
+func (h *UserHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	login, err := h.service.ValidateToken(r.Context(), getTokenFromHeader(r))
+	if err != nil {
+		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "not authorized"}})
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if err := h.service.RevokeSession(r.Context(), login, id); err != nil {
+		if err.Error() == "not found" {
+			writeJSON(w, r, http.StatusNotFound, &APIResponse{Error: &APIError{Code: 404, Text: "session not found"}})
+			return
+		}
+		h.log.Error("revoke session", "err", err)
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "failed to revoke session"}})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Response: map[string]bool{id: true}})
+}
+
+// DELETE /api/sessions ends every session but the current one.
+func (h *UserHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	token := getTokenFromHeader(r)
+	login, err := h.service.ValidateToken(r.Context(), token)
+	if err != nil {
+		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "not authorized"}})
+		return
+	}
+	n, err := h.service.RevokeOtherSessions(r.Context(), login, token)
+	if err != nil {
+		h.log.Error("revoke sessions", "err", err)
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "failed to revoke sessions"}})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Data: map[string]int{"revoked": n}})
+}
+
+// APIKeyAnswer is a newly created API key; the key is only ever shown here.
+type APIKeyAnswer struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+// POST /api/keys
+// API keys are managed with a session token only, never with another key.
+func (h *UserHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	login, err := h.service.ValidateToken(r.Context(), getTokenFromHeader(r))
+	if err != nil {
+		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "not authorized"}})
+		return
+	}
+	var opts service.APIKeyOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid json"}})
+		return
+	}
+	key, secret, err := h.service.CreateAPIKey(r.Context(), login, opts)
+	if err != nil {
+		if errors.Is(err, service.ErrAPIKeyOptions) {
+			writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: err.Error()}})
+			return
+		}
+		h.log.Error("create api key", "err", err)
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "failed to create api key"}})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Data: APIKeyAnswer{APIKey: key, Key: secret}})
+}
+
+// GET /api/keys
+func (h *UserHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	login, err := h.service.ValidateToken(r.Context(), getTokenFromHeader(r))
+	if err != nil {
+		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "not authorized"}})
+		return
+	}
+	keys, err := h.service.ListAPIKeys(r.Context(), login)
+	if err != nil {
+		h.log.Error("list api keys", "err", err)
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "failed to list api keys"}})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Data: map[string]any{"keys": keys}})
+}
+
+// DELETE /api/keys/{id}
+func (h *UserHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	login, err := h.service.ValidateToken(r.Context(), getTokenFromHeader(r))
+	if err != nil {
+		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "not authorized"}})
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if err := h.service.RevokeAPIKey(r.Context(), login, id); err != nil {
+		if err.Error() == "not found" {
+			writeJSON(w, r, http.StatusNotFound, &APIResponse{Error: &APIError{Code: 404, Text: "api key not found"}})
+			return
+		}
+		h.log.Error("revoke api key", "err", err)
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "failed to revoke api key"}})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Response: map[string]bool{id: true}})
+}
@@ -1,14 +1,18 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"web-server/internal/config"
 	"web-server/internal/models"
 	"web-server/internal/service"
 
@@ -18,84 +22,148 @@ import (
 type DocumentHandler struct {
 	svc         service.DocumentService
 	userService service.UserService
+	limits      config.UploadsCfg
 }
 
-func NewDocumentHandler(svc service.DocumentService, us service.UserService) *DocumentHandler {
-	return &DocumentHandler{svc: svc, userService: us}
+func NewDocumentHandler(svc service.DocumentService, us service.UserService, limits config.UploadsCfg) *DocumentHandler {
+	return &DocumentHandler{svc: svc, userService: us, limits: limits}
+}
+
+// maxFieldSize caps the non-file multipart fields, which are read into memory.
+const maxFieldSize = 1 << 20
+
+// sizeLimitReader fails once more than max bytes have been read. A
+// non-positive max means no limit.
+type sizeLimitReader struct {
+	r        io.Reader
+	left     int64
+	exceeded bool
+}
+
+var errTooLarge = errors.New("upload too large")
+
+func newSizeLimitReader(r io.Reader, max int64) *sizeLimitReader {
+	if max <= 0 {
+		max = -1
+	}
+	return &sizeLimitReader{r: r, left: max}
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return l.r.Read(p)
+	}
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.left {
+		l.exceeded = true
+		return int(l.left), errTooLarge
+	}
+	l.left -= int64(n)
+	return n, err
+}
+
+func writeTooLarge(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusRequestEntityTooLarge, &APIResponse{Error: &APIError{Code: 413, Text: "upload too large"}})
 }
 
 // UploadDoc (POST /api/docs)
+//
+// The multipart body is read part by part: "meta" and the optional "json"
+// must come before "file", which is streamed straight into storage.
 func (h *DocumentHandler) UploadDoc(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeJSON(w, r, http.StatusMethodNotAllowed, &APIResponse{Error: &APIError{Code: 405, Text: "method not allowed"}})
 		return
 	}
 
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid multipart"}})
-		return
-	}
-
-	token := getTokenFromHeader(r)
-
-	metaRaw := r.FormValue("meta")
-	if metaRaw == "" {
-		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "meta required"}})
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsWrite)
+	if !ok {
 		return
 	}
 
-	var meta models.DocumentMeta
-	if err := json.Unmarshal([]byte(metaRaw), &meta); err != nil {
-		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid meta"}})
+	maxSize := h.limits.MaxSize(userLogin)
+	if maxSize > 0 && r.ContentLength > maxSize+2*maxFieldSize {
+		writeTooLarge(w, r)
 		return
 	}
 
-	userLogin, err := h.userService.ValidateToken(r.Context(), token)
+	mr, err := r.MultipartReader()
 	if err != nil {
-		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "unauthorized"}})
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid multipart"}})
 		return
 	}
 
+	// Large files may take longer than the server-wide timeouts allow.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	var meta *models.DocumentMeta
 	var jsonData map[string]any
-	if j := r.FormValue("json"); j != "" {
-		if err := json.Unmarshal([]byte(j), &jsonData); err != nil {
-			writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid json"}})
-			return
+	var docID, fileName string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
 		}
-	}
-
-	var fileName string
-	if meta.File {
-		file, _, err := r.FormFile("file")
 		if err != nil {
-			writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "file required"}})
+			writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid multipart"}})
 			return
 		}
-		defer file.Close()
 
-		if err := os.MkdirAll("uploads", 0755); err != nil {
-			writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "storage error"}})
-			return
-		}
-		dst := filepath.Join("uploads", meta.Name)
-		out, err := os.Create(dst)
-		if err != nil {
-			writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "storage error"}})
-			return
-		}
-		defer out.Close()
-		if _, err := io.Copy(out, file); err != nil {
-			writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "storage error"}})
-			return
+		switch part.FormName() {
+		case "meta":
+			meta = &models.DocumentMeta{}
+			if err := json.NewDecoder(io.LimitReader(part, maxFieldSize)).Decode(meta); err != nil {
+				writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid meta"}})
+				return
+			}
+		case "json":
+			if err := json.NewDecoder(io.LimitReader(part, maxFieldSize)).Decode(&jsonData); err != nil && err != io.EOF {
+				writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid json"}})
+				return
+			}
+		case "file":
+			if meta == nil {
+				writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "meta must precede file"}})
+				return
+			}
+			if !meta.File || docID != "" {
+				continue
+			}
+			file := newSizeLimitReader(part, maxSize)
+			docID, err = h.svc.CreateDocument(r.Context(), userLogin, *meta, jsonData, file)
+			if file.exceeded {
+				writeTooLarge(w, r)
+				return
+			}
+			if err != nil {
+				writeCreateError(w, r, err)
+				return
+			}
+			fileName = meta.Name
 		}
-		fileName = meta.Name
+		part.Close()
 	}
 
-	docID, err := h.svc.CreateDocument(r.Context(), userLogin, meta, jsonData)
-	if err != nil {
-		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "create error"}})
+	if meta == nil {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "meta required"}})
+		return
+	}
+	if meta.File && docID == "" {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "file required"}})
 		return
 	}
+	if !meta.File {
+		docID, err = h.svc.CreateDocument(r.Context(), userLogin, *meta, jsonData, nil)
+		if err != nil {
+			writeCreateError(w, r, err)
+			return
+		}
+	}
 
 	writeJSON(w, r, http.StatusOK, &APIResponse{
 		Data: map[string]any{
@@ -106,6 +174,26 @@ func (h *DocumentHandler) UploadDoc(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func writeCreateError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, service.ErrUnknownUser) {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "unknown user in grants"}})
+		return
+	}
+	if errors.Is(err, service.ErrInvalidGrant) {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid grant"}})
+		return
+	}
+	if errors.Is(err, service.ErrUnknownGroup) {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "unknown group in grants"}})
+		return
+	}
+	if errors.Is(err, service.ErrNotGroupMember) {
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "not a member of the group"}})
+		return
+	}
+	writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: "create error"}})
+}
+
 // ListDocs (GET|HEAD /api/docs)
 func (h *DocumentHandler) ListDocs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet && r.Method != http.MethodHead {
@@ -113,12 +201,9 @@ func (h *DocumentHandler) ListDocs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token := getTokenFromHeader(r)
-	userLogin, err := h.userService.ValidateToken(r.Context(), token)
-	if err != nil {
-		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{
-			Error: &APIError{Code: 401, Text: "unauthorized"},
-		})
+	// Without a token only public documents are listed.
+	userLogin, ok := optionalLogin(w, r, h.userService, models.ScopeDocsRead)
+	if !ok {
 		return
 	}
 
@@ -134,24 +219,8 @@ func (h *DocumentHandler) ListDocs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	answers := []Answer{}
-	for _, doc := range docs {
-		answer := Answer{
-			ID:      doc.ID,
-			Name:    doc.Name,
-			Mime:    doc.Mime,
-			File:    doc.File,
-			Public:  doc.Public,
-			Created: doc.CreatedAt.Format(time.DateTime),
-			Grant:   doc.Grants,
-		}
-		if len(doc.JSONRaw) > 0 {
-			err := json.Unmarshal(doc.JSONRaw, &answer.Json)
-			if err != nil {
-				writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: err.Error()}})
-			}
-		}
-		answers = append(answers, answer)
-
+	for i := range docs {
+		answers = append(answers, toAnswer(&docs[i]))
 	}
 
 	if r.Method == http.MethodHead {
@@ -172,16 +241,18 @@ func (h *DocumentHandler) GetDoc(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id := mux.Vars(r)["id"]
-	token := getTokenFromHeader(r)
-
-	userLogin, err := h.userService.ValidateToken(r.Context(), token)
-	if err != nil {
-		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "unauthorized"}})
+	// Public documents can be read without a token.
+	userLogin, ok := optionalLogin(w, r, h.userService, models.ScopeDocsRead)
+	if !ok {
 		return
 	}
 
-	doc, filePath, mime, jsonData, err := h.svc.GetDocument(r.Context(), userLogin, id)
+	doc, file, mimeType, jsonData, err := h.svc.GetDocument(r.Context(), userLogin, id)
 	if err != nil {
+		if err.Error() == "forbidden" && userLogin == anonymous {
+			writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "unauthorized"}})
+			return
+		}
 		if err.Error() == "forbidden" {
 			writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "access denied"}})
 			return
@@ -189,19 +260,84 @@ func (h *DocumentHandler) GetDoc(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: err.Error()}})
 		return
 	}
+	if file != nil {
+		defer file.Close()
+	}
+	doc.Mime = mimeType
+	serveDocument(w, r, doc, file, jsonData)
+}
 
-	if r.Method == http.MethodHead {
-		w.WriteHeader(http.StatusOK)
+// serveDocument writes the file of doc, or its JSON payload.
+func serveDocument(w http.ResponseWriter, r *http.Request, doc *models.Document, file io.ReadSeeker, jsonData map[string]any) {
+	// http.ServeContent takes care of If-None-Match, If-Modified-Since,
+	// Range and If-Range, and of HEAD requests.
+	if doc.Public {
+		w.Header().Set("Cache-Control", "public, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+	w.Header().Set("ETag", documentETag(doc))
+	if doc.File && file != nil {
+		if doc.Mime != "" {
+			w.Header().Set("Content-Type", doc.Mime)
+		}
+		if doc.Name != "" {
+			w.Header().Set("Content-Disposition", contentDisposition(doc.Name))
+		}
+		// Large files and media streams may take longer than the
+		// server-wide write timeout allows.
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		http.ServeContent(w, r, doc.Name, doc.UpdatedAt, file)
 		return
 	}
 
-	if doc.File && filePath != "" {
-		w.Header().Set("Content-Type", mime)
-		http.ServeFile(w, r, filePath)
+	body, err := json.Marshal(&APIResponse{Data: jsonData})
+	if err != nil {
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: err.Error()}})
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeContent(w, r, "", doc.UpdatedAt, bytes.NewReader(body))
+}
 
-	writeJSON(w, r, http.StatusOK, &APIResponse{Data: jsonData})
+// contentDisposition keeps the original file name, RFC 2231 encoded when it
+// is not plain ASCII.
+func contentDisposition(name string) string {
+	if v := mime.FormatMediaType("inline", map[string]string{"filename": name}); v != "" {
+		return v
+	}
+	return "inline"
+}
+
+// documentETag is the document version, followed by the content digest for
+// files. Every change bumps the version, so the tag is a strong validator.
+func documentETag(doc *models.Document) string {
+	if doc.Digest != "" {
+		return fmt.Sprintf(`"%d-%s"`, doc.Version, doc.Digest)
+	}
+	return fmt.Sprintf(`"%d"`, doc.Version)
+}
+
+// parseIfMatch returns the versions named by If-Match, or nil if the header
+// is absent or "*". Weak and foreign tags never match, so a header made only
+// of those yields an empty, non-nil slice.
+func parseIfMatch(r *http.Request) []int64 {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" || h == "*" {
+		return nil
+	}
+	versions := []int64{}
+	for _, tag := range strings.Split(h, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") || len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		v, _, _ := strings.Cut(tag[1:len(tag)-1], "-")
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			versions = append(versions, n)
+		}
+	}
+	return versions
 }
 
 // DeleteDoc (DELETE /api/docs/{id})
@@ -212,19 +348,20 @@ func (h *DocumentHandler) DeleteDoc(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id := mux.Vars(r)["id"]
-	token := getTokenFromHeader(r)
-
-	userLogin, err := h.userService.ValidateToken(r.Context(), token)
-	if err != nil {
-		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "unauthorized"}})
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsDelete)
+	if !ok {
 		return
 	}
 
-	if err := h.svc.DeleteDocument(r.Context(), userLogin, id); err != nil {
+	if err := h.svc.DeleteDocument(r.Context(), userLogin, id, parseIfMatch(r)); err != nil {
 		if err.Error() == "forbidden" {
 			writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "cannot delete"}})
 			return
 		}
+		if errors.Is(err, service.ErrPreconditionFailed) {
+			writeJSON(w, r, http.StatusPreconditionFailed, &APIResponse{Error: &APIError{Code: 412, Text: "version mismatch"}})
+			return
+		}
 		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: err.Error()}})
 		return
 	}
@@ -233,3 +370,170 @@ func (h *DocumentHandler) DeleteDoc(w http.ResponseWriter, r *http.Request) {
 		Response: map[string]bool{id: true},
 	})
 }
+
+func writeUpdateError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, service.ErrPreconditionFailed) {
+		writeJSON(w, r, http.StatusPreconditionFailed, &APIResponse{Error: &APIError{Code: 412, Text: "version mismatch"}})
+		return
+	}
+	if errors.Is(err, service.ErrUnknownUser) {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "unknown user in grants"}})
+		return
+	}
+	if errors.Is(err, service.ErrInvalidGrant) {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid grant"}})
+		return
+	}
+	if errors.Is(err, service.ErrUnknownGroup) {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "unknown group in grants"}})
+		return
+	}
+	if errors.Is(err, service.ErrNotGroupMember) {
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "not a member of the group"}})
+		return
+	}
+	if errors.Is(err, service.ErrRevisionNotFound) {
+		writeJSON(w, r, http.StatusNotFound, &APIResponse{Error: &APIError{Code: 404, Text: "revision not found"}})
+		return
+	}
+	switch err.Error() {
+	case "forbidden":
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "cannot modify"}})
+	case "file required", "legacy file cannot be renamed", "invalid max_revisions":
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: err.Error()}})
+	default:
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: err.Error()}})
+	}
+}
+
+// PatchDoc (PATCH /api/docs/{id}) changes name, mime, public and grants.
+// With a JSON Patch or JSON Merge Patch content type it edits the JSON
+// payload instead and responds with the new payload.
+func (h *DocumentHandler) PatchDoc(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsWrite)
+	if !ok {
+		return
+	}
+
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct == service.ContentTypeJSONPatch || ct == service.ContentTypeMergePatch {
+		patch, err := io.ReadAll(io.LimitReader(r.Body, maxFieldSize))
+		if err != nil {
+			writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid body"}})
+			return
+		}
+		doc, err := h.svc.PatchJSON(r.Context(), userLogin, id, ct, patch, parseIfMatch(r))
+		switch {
+		case errors.Is(err, service.ErrInvalidPatch):
+			writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: err.Error()}})
+		case errors.Is(err, service.ErrPatchTestFailed):
+			writeJSON(w, r, http.StatusConflict, &APIResponse{Error: &APIError{Code: 409, Text: err.Error()}})
+		case errors.Is(err, service.ErrPatchNotApplicable):
+			writeJSON(w, r, http.StatusUnprocessableEntity, &APIResponse{Error: &APIError{Code: 422, Text: err.Error()}})
+		case err != nil:
+			writeUpdateError(w, r, err)
+		default:
+			w.Header().Set("ETag", documentETag(doc))
+			writeJSON(w, r, http.StatusOK, &APIResponse{Data: json.RawMessage(doc.JSONRaw)})
+		}
+		return
+	}
+
+	var patch models.DocumentPatch
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFieldSize)).Decode(&patch); err != nil {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid json"}})
+		return
+	}
+
+	doc, err := h.svc.UpdateDocument(r.Context(), userLogin, id, patch, parseIfMatch(r))
+	if err != nil {
+		writeUpdateError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", documentETag(doc))
+	writeJSON(w, r, http.StatusOK, &APIResponse{Data: toAnswer(doc)})
+}
+
+// PutDoc (PUT /api/docs/{id}) replaces the content of a document.
+//
+// An application/json body becomes the new JSON payload. Otherwise the body
+// is multipart with an optional "json" part followed by "file", which is
+// required for file documents and streamed like in UploadDoc.
+func (h *DocumentHandler) PutDoc(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsWrite)
+	if !ok {
+		return
+	}
+
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+		var jsonData map[string]any
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxFieldSize)).Decode(&jsonData); err != nil {
+			writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid json"}})
+			return
+		}
+		doc, err := h.svc.ReplaceDocument(r.Context(), userLogin, id, jsonData, nil, parseIfMatch(r))
+		if err != nil {
+			writeUpdateError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", documentETag(doc))
+		writeJSON(w, r, http.StatusOK, &APIResponse{Data: toAnswer(doc)})
+		return
+	}
+
+	maxSize := h.limits.MaxSize(userLogin)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid multipart"}})
+		return
+	}
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	var jsonData map[string]any
+	var doc *models.Document
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid multipart"}})
+			return
+		}
+		switch part.FormName() {
+		case "json":
+			if err := json.NewDecoder(io.LimitReader(part, maxFieldSize)).Decode(&jsonData); err != nil && err != io.EOF {
+				writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid json"}})
+				return
+			}
+		case "file":
+			if doc != nil {
+				continue
+			}
+			file := newSizeLimitReader(part, maxSize)
+			doc, err = h.svc.ReplaceDocument(r.Context(), userLogin, id, jsonData, file, parseIfMatch(r))
+			if file.exceeded {
+				writeTooLarge(w, r)
+				return
+			}
+			if err != nil {
+				writeUpdateError(w, r, err)
+				return
+			}
+		}
+		part.Close()
+	}
+	if doc == nil {
+		doc, err = h.svc.ReplaceDocument(r.Context(), userLogin, id, jsonData, nil, parseIfMatch(r))
+		if err != nil {
+			writeUpdateError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("ETag", documentETag(doc))
+	writeJSON(w, r, http.StatusOK, &APIResponse{Data: toAnswer(doc)})
+}
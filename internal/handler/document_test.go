@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSizeLimitReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		max     int64
+		want    string
+		wantErr error
+	}{
+		{"no limit", "abcdef", 0, "abcdef", nil},
+		{"negative means no limit", "abcdef", -1, "abcdef", nil},
+		{"under", "abc", 5, "abc", nil},
+		{"exact", "abcde", 5, "abcde", nil},
+		{"one over", "abcdef", 5, "abcde", errTooLarge},
+		{"far over", strings.Repeat("x", 100), 5, "xxxxx", errTooLarge},
+		{"empty", "", 5, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, wrap := range []func(io.Reader) io.Reader{
+				func(r io.Reader) io.Reader { return r },
+				iotest.OneByteReader,
+			} {
+				l := newSizeLimitReader(wrap(strings.NewReader(tt.body)), tt.max)
+				got, err := io.ReadAll(l)
+				if string(got) != tt.want || !errors.Is(err, tt.wantErr) {
+					t.Errorf("read %q, %v; want %q, %v", got, err, tt.want, tt.wantErr)
+				}
+				if l.exceeded != (tt.wantErr != nil) {
+					t.Errorf("exceeded = %v", l.exceeded)
+				}
+			}
+		})
+	}
+}
+
+func TestParseIfMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []int64
+	}{
+		{"", nil},
+		{"*", nil},
+		{" * ", nil},
+		{`"3"`, []int64{3}},
+		{`"3-abc"`, []int64{3}},
+		{`"3-abc", "5"`, []int64{3, 5}},
+		{`"3",W/"4"`, []int64{3}},
+		{`W/"4"`, []int64{}},
+		{`3`, []int64{}},
+		{`"x-3"`, []int64{}},
+		{`"`, []int64{}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PUT", "/api/docs/1", nil)
+		if tt.header != "" {
+			r.Header.Set("If-Match", tt.header)
+		}
+		got := parseIfMatch(r)
+		if (got == nil) != (tt.want == nil) || !slices.Equal(got, tt.want) {
+			t.Errorf("parseIfMatch(%q) = %#v, want %#v", tt.header, got, tt.want)
+		}
+	}
+}
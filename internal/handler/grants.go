@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"web-server/internal/models"
+	"web-server/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+func writeGrantError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrPreconditionFailed):
+		writeJSON(w, r, http.StatusPreconditionFailed, &APIResponse{Error: &APIError{Code: 412, Text: "version mismatch"}})
+	case errors.Is(err, service.ErrUnknownUser):
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "unknown user"}})
+	case errors.Is(err, service.ErrUnknownGroup):
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "unknown group"}})
+	case errors.Is(err, service.ErrInvalidGrant):
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid grant"}})
+	case errors.Is(err, service.ErrNotGroupMember):
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "not a member of the group"}})
+	case err.Error() == "forbidden":
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "access denied"}})
+	case err.Error() == "not found":
+		writeJSON(w, r, http.StatusNotFound, &APIResponse{Error: &APIError{Code: 404, Text: "grant not found"}})
+	default:
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: err.Error()}})
+	}
+}
+
+// ListGrants (GET /api/docs/{id}/grants)
+func (h *DocumentHandler) ListGrants(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsRead)
+	if !ok {
+		return
+	}
+	grants, err := h.svc.ListGrants(r.Context(), userLogin, mux.Vars(r)["id"])
+	if err != nil {
+		writeGrantError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Data: map[string]any{"grants": grants},
+	})
+}
+
+// AddGrant (POST /api/docs/{id}/grants) grants or changes the role of one
+// user or group: {"login": "login1", "role": "editor"} or
+// {"group": "team", "role": "viewer"}. Honours If-Match.
+func (h *DocumentHandler) AddGrant(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsWrite)
+	if !ok {
+		return
+	}
+	var g models.Grant
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFieldSize)).Decode(&g); err != nil {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid grant"}})
+		return
+	}
+	grants, err := h.svc.AddGrant(r.Context(), userLogin, mux.Vars(r)["id"], g, parseIfMatch(r))
+	if err != nil {
+		writeGrantError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Data: map[string]any{"grants": grants},
+	})
+}
+
+// RevokeGrant (DELETE /api/docs/{id}/grants/{login} and
+// /api/docs/{id}/grants/groups/{group}). Honours If-Match.
+func (h *DocumentHandler) RevokeGrant(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsWrite)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	grants, err := h.svc.RevokeGrant(r.Context(), userLogin, vars["id"], models.Grant{Login: vars["login"], Group: vars["group"]}, parseIfMatch(r))
+	if err != nil {
+		writeGrantError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Data: map[string]any{"grants": grants},
+	})
+}
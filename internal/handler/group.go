@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"web-server/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+type GroupHandler struct {
+	svc         service.GroupService
+	userService service.UserService
+}
+
+func NewGroupHandler(svc service.GroupService, us service.UserService) *GroupHandler {
+	return &GroupHandler{svc: svc, userService: us}
+}
+
+func writeGroupError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidGroupName):
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid group name"}})
+	case errors.Is(err, service.ErrUnknownUser):
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "unknown user"}})
+	case errors.Is(err, service.ErrGroupNotFound):
+		writeJSON(w, r, http.StatusNotFound, &APIResponse{Error: &APIError{Code: 404, Text: "group not found"}})
+	case errors.Is(err, service.ErrGroupExists):
+		writeJSON(w, r, http.StatusConflict, &APIResponse{Error: &APIError{Code: 409, Text: "group exists"}})
+	case err.Error() == "forbidden":
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "access denied"}})
+	case err.Error() == "not found":
+		writeJSON(w, r, http.StatusNotFound, &APIResponse{Error: &APIError{Code: 404, Text: "not a member"}})
+	default:
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: err.Error()}})
+	}
+}
+
+// auth returns the login of the bearer token, or "" if a response was
+// written.
+func (h *GroupHandler) auth(w http.ResponseWriter, r *http.Request) string {
+	userLogin, err := h.userService.ValidateToken(r.Context(), getTokenFromHeader(r))
+	if err != nil {
+		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "unauthorized"}})
+		return ""
+	}
+	return userLogin
+}
+
+// decodeField reads a {"<field>": "..."} request body.
+func decodeField(r *http.Request, field string) string {
+	var body map[string]string
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFieldSize)).Decode(&body); err != nil {
+		return ""
+	}
+	return body[field]
+}
+
+// List (GET /api/groups) returns the groups the user owns or belongs to.
+func (h *GroupHandler) List(w http.ResponseWriter, r *http.Request) {
+	userLogin := h.auth(w, r)
+	if userLogin == "" {
+		return
+	}
+	groups, err := h.svc.List(r.Context(), userLogin)
+	if err != nil {
+		writeGroupError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Data: map[string]any{"groups": groups},
+	})
+}
+
+// Create (POST /api/groups) {"name": "team"}
+func (h *GroupHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userLogin := h.auth(w, r)
+	if userLogin == "" {
+		return
+	}
+	g, err := h.svc.Create(r.Context(), userLogin, decodeField(r, "name"))
+	if err != nil {
+		writeGroupError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Data: g})
+}
+
+// Get (GET /api/groups/{name})
+func (h *GroupHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userLogin := h.auth(w, r)
+	if userLogin == "" {
+		return
+	}
+	g, err := h.svc.Get(r.Context(), userLogin, mux.Vars(r)["name"])
+	if err != nil {
+		writeGroupError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Data: g})
+}
+
+// Delete (DELETE /api/groups/{name})
+func (h *GroupHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userLogin := h.auth(w, r)
+	if userLogin == "" {
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if err := h.svc.Delete(r.Context(), userLogin, name); err != nil {
+		writeGroupError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Response: map[string]bool{name: true},
+	})
+}
+
+// AddMember (POST /api/groups/{name}/members) {"login": "login1"}
+func (h *GroupHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	userLogin := h.auth(w, r)
+	if userLogin == "" {
+		return
+	}
+	login := decodeField(r, "login")
+	if login == "" {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "login required"}})
+		return
+	}
+	g, err := h.svc.AddMember(r.Context(), userLogin, mux.Vars(r)["name"], login)
+	if err != nil {
+		writeGroupError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Data: g})
+}
+
+// RemoveMember (DELETE /api/groups/{name}/members/{login})
+func (h *GroupHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	userLogin := h.auth(w, r)
+	if userLogin == "" {
+		return
+	}
+	vars := mux.Vars(r)
+	g, err := h.svc.RemoveMember(r.Context(), userLogin, vars["name"], vars["login"])
+	if err != nil {
+		writeGroupError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Data: g})
+}
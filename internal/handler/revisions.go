@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"web-server/internal/models"
+	"web-server/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+func parseVersion(s string) (int64, bool) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	return v, err == nil && v > 0
+}
+
+func writeRevisionError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrRevisionNotFound):
+		writeJSON(w, r, http.StatusNotFound, &APIResponse{Error: &APIError{Code: 404, Text: "revision not found"}})
+	case err.Error() == "forbidden":
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "access denied"}})
+	default:
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: err.Error()}})
+	}
+}
+
+// ListRevisions (GET /api/docs/{id}/revisions)
+func (h *DocumentHandler) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsRead)
+	if !ok {
+		return
+	}
+	revs, err := h.svc.ListRevisions(r.Context(), userLogin, mux.Vars(r)["id"])
+	if err != nil {
+		writeRevisionError(w, r, err)
+		return
+	}
+	answers := make([]RevisionAnswer, 0, len(revs))
+	for i := range revs {
+		answers = append(answers, RevisionAnswer{
+			Version: revs[i].Version,
+			Name:    revs[i].Name,
+			Mime:    revs[i].Mime,
+			File:    revs[i].File,
+			Size:    revs[i].Size,
+			Updated: revs[i].UpdatedAt.Format(time.DateTime),
+			ETag:    documentETag(&revs[i]),
+			Current: i == 0,
+		})
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Data: map[string]any{"revisions": answers},
+	})
+}
+
+// GetRevision (GET|HEAD /api/docs/{id}/revisions/{version}) serves the
+// content of one version like GetDoc does for the current one.
+func (h *DocumentHandler) GetRevision(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsRead)
+	if !ok {
+		return
+	}
+	version, ok := parseVersion(mux.Vars(r)["version"])
+	if !ok {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid version"}})
+		return
+	}
+	rev, file, jsonData, err := h.svc.GetRevision(r.Context(), userLogin, mux.Vars(r)["id"], version)
+	if err != nil {
+		writeRevisionError(w, r, err)
+		return
+	}
+	if file != nil {
+		defer file.Close()
+	}
+	serveDocument(w, r, rev, file, jsonData)
+}
+
+// DiffRevisions (GET /api/docs/{id}/revisions/diff?from=&to=) responds with
+// the JSON Merge Patch from one payload to the other. to defaults to the
+// current version.
+func (h *DocumentHandler) DiffRevisions(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsRead)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	from, ok := parseVersion(q.Get("from"))
+	if !ok {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid from"}})
+		return
+	}
+	var to int64
+	if q.Get("to") != "" {
+		if to, ok = parseVersion(q.Get("to")); !ok {
+			writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid to"}})
+			return
+		}
+	}
+
+	diff, to, err := h.svc.DiffRevisions(r.Context(), userLogin, mux.Vars(r)["id"], from, to)
+	if err != nil {
+		writeRevisionError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Data: map[string]any{"from": from, "to": to, "patch": json.RawMessage(diff)},
+	})
+}
+
+// RestoreRevision (POST /api/docs/{id}/revisions/{version}/restore)
+func (h *DocumentHandler) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsWrite)
+	if !ok {
+		return
+	}
+	version, ok := parseVersion(mux.Vars(r)["version"])
+	if !ok {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid version"}})
+		return
+	}
+	doc, err := h.svc.RestoreRevision(r.Context(), userLogin, mux.Vars(r)["id"], version, parseIfMatch(r))
+	if err != nil {
+		writeUpdateError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", documentETag(doc))
+	writeJSON(w, r, http.StatusOK, &APIResponse{Data: toAnswer(doc)})
+}
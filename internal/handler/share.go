@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"web-server/internal/models"
+	"web-server/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+type ShareHandler struct {
+	svc         service.ShareService
+	userService service.UserService
+}
+
+func NewShareHandler(svc service.ShareService, us service.UserService) *ShareHandler {
+	return &ShareHandler{svc: svc, userService: us}
+}
+
+// ShareLinkAnswer is a newly created link; the token is only ever shown
+// here.
+type ShareLinkAnswer struct {
+	*models.ShareLink
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+func writeShareError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidLink):
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid link options"}})
+	case errors.Is(err, service.ErrLinkPassword):
+		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "password required"}})
+	case errors.Is(err, service.ErrLinkNotFound):
+		writeJSON(w, r, http.StatusNotFound, &APIResponse{Error: &APIError{Code: 404, Text: "link not found"}})
+	case errors.Is(err, service.ErrLinkExpired), errors.Is(err, service.ErrLinkExhausted):
+		writeJSON(w, r, http.StatusGone, &APIResponse{Error: &APIError{Code: 410, Text: err.Error()}})
+	case err.Error() == "forbidden":
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "access denied"}})
+	default:
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: err.Error()}})
+	}
+}
+
+// CreateLink (POST /api/docs/{id}/links)
+func (h *ShareHandler) CreateLink(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsWrite)
+	if !ok {
+		return
+	}
+	var opts service.ShareOptions
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFieldSize)).Decode(&opts); err != nil && err != io.EOF {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid link options"}})
+		return
+	}
+	link, token, err := h.svc.Create(r.Context(), userLogin, mux.Vars(r)["id"], opts)
+	if err != nil {
+		writeShareError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Data: ShareLinkAnswer{ShareLink: link, Token: token, URL: "/api/share/" + token},
+	})
+}
+
+// ListLinks (GET /api/docs/{id}/links)
+func (h *ShareHandler) ListLinks(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsRead)
+	if !ok {
+		return
+	}
+	links, err := h.svc.List(r.Context(), userLogin, mux.Vars(r)["id"])
+	if err != nil {
+		writeShareError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Data: map[string]any{"links": links},
+	})
+}
+
+// RevokeLink (DELETE /api/docs/{id}/links/{link})
+func (h *ShareHandler) RevokeLink(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsWrite)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	if err := h.svc.Revoke(r.Context(), userLogin, vars["id"], vars["link"]); err != nil {
+		writeShareError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Response: map[string]bool{vars["link"]: true},
+	})
+}
+
+// Download (GET|HEAD /api/share/{token}) needs no account. The password of a
+// protected link goes in X-Share-Password; it is never read from the URL,
+// which would leave it in access logs and browser history. Every GET counts
+// as a download, ranged or not.
+func (h *ShareHandler) Download(w http.ResponseWriter, r *http.Request) {
+	password := r.Header.Get("X-Share-Password")
+	doc, file, jsonData, err := h.svc.Open(r.Context(), mux.Vars(r)["token"], password, r.Method == http.MethodGet)
+	if err != nil {
+		writeShareError(w, r, err)
+		return
+	}
+	if file != nil {
+		defer file.Close()
+	}
+	serveDocument(w, r, doc, file, jsonData)
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"web-server/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+// TransferHandler serves ownership transfers. Besides a user's session
+// token it accepts the admin token from the config, acting as
+// service.AdminActor.
+type TransferHandler struct {
+	svc         service.TransferService
+	userService service.UserService
+	adminToken  string
+}
+
+func NewTransferHandler(svc service.TransferService, us service.UserService, adminToken string) *TransferHandler {
+	return &TransferHandler{svc: svc, userService: us, adminToken: adminToken}
+}
+
+// transferRequest is the body of both transfer endpoints; From is only
+// read by TransferAll.
+type transferRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Keep string `json:"keep"`
+}
+
+func (h *TransferHandler) actor(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := getTokenFromHeader(r)
+	if isAdminToken(token, h.adminToken) {
+		return service.AdminActor, true
+	}
+	login, err := h.userService.ValidateToken(r.Context(), token)
+	if err != nil {
+		writeJSON(w, r, http.StatusUnauthorized, &APIResponse{Error: &APIError{Code: 401, Text: "unauthorized"}})
+		return "", false
+	}
+	return login, true
+}
+
+func writeTransferError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrPreconditionFailed):
+		writeJSON(w, r, http.StatusPreconditionFailed, &APIResponse{Error: &APIError{Code: 412, Text: "version mismatch"}})
+	case errors.Is(err, service.ErrUnknownUser):
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "unknown user"}})
+	case errors.Is(err, service.ErrInvalidTransfer):
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid transfer"}})
+	case err.Error() == "forbidden":
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "access denied"}})
+	case err.Error() == "not found":
+		writeJSON(w, r, http.StatusNotFound, &APIResponse{Error: &APIError{Code: 404, Text: "document not found"}})
+	default:
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: err.Error()}})
+	}
+}
+
+func decodeTransfer(w http.ResponseWriter, r *http.Request) (transferRequest, bool) {
+	var req transferRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFieldSize)).Decode(&req); err != nil {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid transfer"}})
+		return req, false
+	}
+	return req, true
+}
+
+// TransferDoc (POST /api/docs/{id}/transfer) hands one document to another
+// user: {"to": "login2", "keep": "editor"}. Honours If-Match.
+func (h *TransferHandler) TransferDoc(w http.ResponseWriter, r *http.Request) {
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeTransfer(w, r)
+	if !ok {
+		return
+	}
+	t, err := h.svc.TransferDocument(r.Context(), actor, mux.Vars(r)["id"], req.To, req.Keep, parseIfMatch(r))
+	if err != nil {
+		writeTransferError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{Response: t})
+}
+
+// TransferAll (POST /api/transfers) hands every document of "from" to "to".
+// Users may only give away their own documents; "from" defaults to them.
+func (h *TransferHandler) TransferAll(w http.ResponseWriter, r *http.Request) {
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeTransfer(w, r)
+	if !ok {
+		return
+	}
+	if req.From == "" && actor != service.AdminActor {
+		req.From = actor
+	}
+	ts, err := h.svc.TransferAll(r.Context(), actor, req.From, req.To, req.Keep)
+	if err != nil {
+		writeTransferError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Data: map[string]any{"transfers": ts},
+	})
+}
+
+// ListTransfers (GET /api/transfers?login=...) returns the audit log; login
+// is only honoured for the admin token.
+func (h *TransferHandler) ListTransfers(w http.ResponseWriter, r *http.Request) {
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	ts, err := h.svc.ListTransfers(r.Context(), actor, r.URL.Query().Get("login"))
+	if err != nil {
+		writeTransferError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Data: map[string]any{"transfers": ts},
+	})
+}
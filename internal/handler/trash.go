@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"net/http"
+
+	"web-server/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+func writeTrashError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err.Error() {
+	case "not found":
+		writeJSON(w, r, http.StatusNotFound, &APIResponse{Error: &APIError{Code: 404, Text: "not in trash"}})
+	case "forbidden":
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "access denied"}})
+	default:
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: err.Error()}})
+	}
+}
+
+// ListTrash (GET /api/trash)
+func (h *DocumentHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsRead)
+	if !ok {
+		return
+	}
+	docs, err := h.svc.ListTrash(r.Context(), userLogin)
+	if err != nil {
+		writeTrashError(w, r, err)
+		return
+	}
+	answers := []Answer{}
+	for i := range docs {
+		answers = append(answers, toAnswer(&docs[i]))
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Data: map[string]any{"docs": answers},
+	})
+}
+
+// RestoreTrash (POST /api/trash/{id}/restore)
+func (h *DocumentHandler) RestoreTrash(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsWrite)
+	if !ok {
+		return
+	}
+	doc, err := h.svc.RestoreDocument(r.Context(), userLogin, mux.Vars(r)["id"])
+	if err != nil {
+		writeTrashError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", documentETag(doc))
+	writeJSON(w, r, http.StatusOK, &APIResponse{Data: toAnswer(doc)})
+}
+
+// PurgeTrash (DELETE /api/trash/{id})
+func (h *DocumentHandler) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsDelete)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if err := h.svc.PurgeDocument(r.Context(), userLogin, id); err != nil {
+		writeTrashError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Response: map[string]bool{id: true},
+	})
+}
+
+// EmptyTrash (DELETE /api/trash)
+func (h *DocumentHandler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	userLogin, ok := authorize(w, r, h.userService, models.ScopeDocsDelete)
+	if !ok {
+		return
+	}
+	n, err := h.svc.EmptyTrash(r.Context(), userLogin)
+	if err != nil {
+		writeTrashError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &APIResponse{
+		Data: map[string]int{"purged": n},
+	})
+}
@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"web-server/internal/config"
+	"web-server/internal/models"
+	"web-server/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+const tusVersion = "1.0.0"
+
+// TusHandler serves resumable uploads following the tus 1.0 protocol
+// (core, creation, termination and expiration extensions).
+type TusHandler struct {
+	svc         service.UploadService
+	userService service.UserService
+	limits      config.UploadsCfg
+}
+
+func NewTusHandler(svc service.UploadService, us service.UserService, limits config.UploadsCfg) *TusHandler {
+	return &TusHandler{svc: svc, userService: us, limits: limits}
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header: comma separated
+// "key base64(value)" pairs, the value being optional.
+func parseUploadMetadata(h string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range strings.Split(h, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			out[fields[0]] = ""
+		case 2:
+			v, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			out[fields[0]] = string(v)
+		default:
+			return nil, errors.New("invalid metadata")
+		}
+	}
+	return out, nil
+}
+
+// tusPrepare sets the common response headers and checks the protocol
+// version and the bearer token. It returns "" if a response was written.
+func (h *TusHandler) tusPrepare(w http.ResponseWriter, r *http.Request) string {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		writeJSON(w, r, http.StatusPreconditionFailed, &APIResponse{Error: &APIError{Code: 412, Text: "unsupported tus version"}})
+		return ""
+	}
+	userLogin, _ := authorize(w, r, h.userService, models.ScopeDocsWrite)
+	return userLogin
+}
+
+func writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrUploadNotFound):
+		writeJSON(w, r, http.StatusNotFound, &APIResponse{Error: &APIError{Code: 404, Text: "upload not found"}})
+	case errors.Is(err, service.ErrUploadExpired):
+		writeJSON(w, r, http.StatusGone, &APIResponse{Error: &APIError{Code: 410, Text: "upload expired"}})
+	case errors.Is(err, service.ErrUnknownUser):
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "unknown user in grants"}})
+	case errors.Is(err, service.ErrInvalidGrant):
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid grant"}})
+	case errors.Is(err, service.ErrUnknownGroup):
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "unknown group in grants"}})
+	case errors.Is(err, service.ErrNotGroupMember):
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "not a member of the group"}})
+	case errors.Is(err, service.ErrOffsetMismatch):
+		writeJSON(w, r, http.StatusConflict, &APIResponse{Error: &APIError{Code: 409, Text: "offset mismatch"}})
+	case errors.Is(err, service.ErrUploadCompleting):
+		writeJSON(w, r, http.StatusConflict, &APIResponse{Error: &APIError{Code: 409, Text: "upload is being completed"}})
+	case errors.Is(err, service.ErrUploadTooLarge):
+		writeJSON(w, r, http.StatusRequestEntityTooLarge, &APIResponse{Error: &APIError{Code: 413, Text: "chunk exceeds Upload-Length"}})
+	case err.Error() == "forbidden":
+		writeJSON(w, r, http.StatusForbidden, &APIResponse{Error: &APIError{Code: 403, Text: "access denied"}})
+	default:
+		writeJSON(w, r, http.StatusInternalServerError, &APIResponse{Error: &APIError{Code: 500, Text: err.Error()}})
+	}
+}
+
+func setUploadHeaders(w http.ResponseWriter, u *models.Upload, docID string) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	if docID != "" {
+		w.Header().Set("X-Document-Id", docID)
+	}
+}
+
+// Options (OPTIONS /api/uploads)
+func (h *TusHandler) Options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination,expiration")
+	if max := h.limits.MaxSize(""); max > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(max, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Create (POST /api/uploads)
+//
+// Upload-Metadata may carry "meta" and "json" with the same content as the
+// multipart fields of POST /api/docs; "filename" and "filetype" as sent by
+// stock tus clients are used when "meta" is absent.
+func (h *TusHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userLogin := h.tusPrepare(w, r)
+	if userLogin == "" {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid Upload-Length"}})
+		return
+	}
+	if max := h.limits.MaxSize(userLogin); max > 0 && length > max {
+		writeTooLarge(w, r)
+		return
+	}
+	md, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid Upload-Metadata"}})
+		return
+	}
+
+	var meta models.DocumentMeta
+	if raw, ok := md["meta"]; ok {
+		if err := json.Unmarshal([]byte(raw), &meta); err != nil {
+			writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid meta"}})
+			return
+		}
+	} else {
+		meta.Name = md["filename"]
+		meta.Mime = md["filetype"]
+	}
+	var jsonRaw []byte
+	if j := md["json"]; j != "" {
+		var jsonData map[string]any
+		if err := json.Unmarshal([]byte(j), &jsonData); err != nil {
+			writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid json"}})
+			return
+		}
+		jsonRaw = []byte(j)
+	}
+
+	u, docID, err := h.svc.Create(r.Context(), userLogin, length, meta, jsonRaw)
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/api/uploads/"+u.ID)
+	setUploadHeaders(w, u, docID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Head (HEAD /api/uploads/{id})
+func (h *TusHandler) Head(w http.ResponseWriter, r *http.Request) {
+	userLogin := h.tusPrepare(w, r)
+	if userLogin == "" {
+		return
+	}
+	u, err := h.svc.Get(r.Context(), userLogin, mux.Vars(r)["id"])
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	setUploadHeaders(w, u, "")
+	w.WriteHeader(http.StatusOK)
+}
+
+// Patch (PATCH /api/uploads/{id})
+func (h *TusHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	userLogin := h.tusPrepare(w, r)
+	if userLogin == "" {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		writeJSON(w, r, http.StatusUnsupportedMediaType, &APIResponse{Error: &APIError{Code: 415, Text: "unsupported content type"}})
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeJSON(w, r, http.StatusBadRequest, &APIResponse{Error: &APIError{Code: 400, Text: "invalid Upload-Offset"}})
+		return
+	}
+
+	// A chunk may take longer than the server-wide timeouts allow.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	u, docID, err := h.svc.Append(r.Context(), userLogin, mux.Vars(r)["id"], offset, r.Body)
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+	setUploadHeaders(w, u, docID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Terminate (DELETE /api/uploads/{id})
+func (h *TusHandler) Terminate(w http.ResponseWriter, r *http.Request) {
+	userLogin := h.tusPrepare(w, r)
+	if userLogin == "" {
+		return
+	}
+	if err := h.svc.Terminate(r.Context(), userLogin, mux.Vars(r)["id"]); err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
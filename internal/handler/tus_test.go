@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"filename d29ybGQ=", map[string]string{"filename": "world"}, false},
+		{"filename d29ybGQ=,filetype dGV4dC9wbGFpbg==", map[string]string{"filename": "world", "filetype": "text/plain"}, false},
+		{" filename d29ybGQ= , is_confidential", map[string]string{"filename": "world", "is_confidential": ""}, false},
+		{"a ,, b", map[string]string{"a": "", "b": ""}, false},
+		{"filename not-base64!", nil, true},
+		{"filename d29ybGQ= extra", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseUploadMetadata(tt.header)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseUploadMetadata(%q) = %v, want error", tt.header, got)
+			}
+			continue
+		}
+		if err != nil || !maps.Equal(got, tt.want) {
+			t.Errorf("parseUploadMetadata(%q) = %v, %v; want %v", tt.header, got, err, tt.want)
+		}
+	}
+}
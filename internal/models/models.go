@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type User struct {
 	ID           string
@@ -9,6 +12,42 @@ type User struct {
 	CreatedAt    time.Time
 }
 
+// Session is one sign-in of a user. The token is never listed; Current
+// marks the session the listing was requested with.
+type Session struct {
+	ID        string    `json:"id"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created"`
+	ExpiresAt time.Time `json:"expires"`
+	// RefreshExpiresAt ends the session for good; nil without refresh
+	// tokens.
+	RefreshExpiresAt *time.Time `json:"refresh_expires,omitempty"`
+	Current          bool       `json:"current"`
+}
+
+// Scopes an API key can carry. Session tokens have all of them.
+const (
+	ScopeDocsRead   = "docs:read"
+	ScopeDocsWrite  = "docs:write"
+	ScopeDocsDelete = "docs:delete"
+)
+
+// APIKey is a long-lived credential of a user for automation. The key
+// itself is shown once, at creation; Prefix tells keys apart afterwards.
+// AllowedIPs holds addresses or CIDR ranges; empty allows any.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Login      string     `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires,omitempty"`
+	CreatedAt  time.Time  `json:"created"`
+	LastUsedAt *time.Time `json:"last_used,omitempty"`
+}
+
 type Document struct {
 	ID        string    `json:"id"`
 	Owner     string    `json:"owner"`
@@ -17,15 +56,142 @@ type Document struct {
 	File      bool      `json:"file"`
 	Public    bool      `json:"public"`
 	CreatedAt time.Time `json:"created"`
-	Grants    []string  `json:"grants"`
-	JSONRaw   []byte    `json:"-"`
+	UpdatedAt time.Time `json:"updated"`
+	Version   int64     `json:"version"`
+	Grants    []Grant   `json:"grants"`
+	Digest    string    `json:"digest,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	// MaxRevisions overrides the configured history length; nil keeps the
+	// default.
+	MaxRevisions *int `json:"max_revisions,omitempty"`
+	// DeletedAt is set while the document is in the trash.
+	DeletedAt *time.Time `json:"deleted,omitempty"`
+	JSONRaw   []byte     `json:"-"`
+}
+
+// Access is what a user may do with a document, each level including the
+// ones below it.
+type Access int
+
+const (
+	AccessNone Access = iota
+	AccessRead
+	AccessWrite
+	AccessManage
+	AccessOwner
+)
+
+// Grant roles: viewers read, editors also change the content, managers also
+// change who has access.
+const (
+	RoleViewer  = "viewer"
+	RoleEditor  = "editor"
+	RoleManager = "manager"
+)
+
+// RoleAccess maps a grant role to the access it gives, AccessNone for an
+// unknown role.
+func RoleAccess(role string) Access {
+	switch role {
+	case RoleViewer:
+		return AccessRead
+	case RoleEditor:
+		return AccessWrite
+	case RoleManager:
+		return AccessManage
+	}
+	return AccessNone
+}
+
+// Grant gives a role to either one user (Login) or every member of a group
+// (Group).
+type Grant struct {
+	Login string `json:"login,omitempty"`
+	Group string `json:"group,omitempty"`
+	Role  string `json:"role"`
+}
+
+// UnmarshalJSON also accepts a bare login, the form grants had before roles,
+// as a viewer grant.
+func (g *Grant) UnmarshalJSON(b []byte) error {
+	var login string
+	if json.Unmarshal(b, &login) == nil {
+		*g = Grant{Login: login, Role: RoleViewer}
+		return nil
+	}
+	type plain Grant
+	var p plain
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	if p.Role == "" {
+		p.Role = RoleViewer
+	}
+	*g = Grant(p)
+	return nil
+}
+
+type Group struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"`
+	Members   []string  `json:"members"`
+	CreatedAt time.Time `json:"created"`
 }
 
 type DocumentMeta struct {
-	Name   string   `json:"name"`
-	Mime   string   `json:"mime"`
-	File   bool     `json:"file"`
-	Public bool     `json:"public"`
-	Token  string   `json:"token"`
-	Grants []string `json:"grants"`
+	Name   string  `json:"name"`
+	Mime   string  `json:"mime"`
+	File   bool    `json:"file"`
+	Public bool    `json:"public"`
+	Token  string  `json:"token"`
+	Grants []Grant `json:"grants"`
+}
+
+// DocumentPatch lists the metadata fields to change; nil fields are kept.
+type DocumentPatch struct {
+	Name         *string  `json:"name"`
+	Mime         *string  `json:"mime"`
+	Public       *bool    `json:"public"`
+	Grants       *[]Grant `json:"grants"`
+	MaxRevisions *int     `json:"max_revisions"`
+}
+
+// ShareLink lets anyone holding its token download a document without an
+// account. Only a hash of the token is stored.
+type ShareLink struct {
+	ID           string    `json:"id"`
+	DocumentID   string    `json:"document_id"`
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created"`
+	ExpiresAt    time.Time `json:"expires"`
+	PasswordHash string    `json:"-"`
+	HasPassword  bool      `json:"has_password"`
+	MaxDownloads *int      `json:"max_downloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+}
+
+// Transfer records a document changing owner. Actor is the login that
+// asked for it, or "admin" for the admin token.
+type Transfer struct {
+	ID         int64     `json:"id"`
+	DocumentID string    `json:"document_id"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Actor      string    `json:"actor"`
+	CreatedAt  time.Time `json:"created"`
+}
+
+// Upload is an in-progress resumable (tus) upload. Each PATCH is stored as a
+// separate part blob; the parts are concatenated once Offset reaches Length.
+type Upload struct {
+	ID        string
+	Owner     string
+	Length    int64
+	Offset    int64
+	Meta      DocumentMeta
+	JSONRaw   []byte
+	Parts     []string
+	CreatedAt time.Time
+	ExpiresAt time.Time
 }
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"web-server/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type APIKeyRepository interface {
+	// Create stores k for k.Login, filling in its id and creation time.
+	Create(ctx context.Context, k *models.APIKey, keyHash string) error
+	// GetByHash returns the key with the given hash and marks it used.
+	GetByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	List(ctx context.Context, login string) ([]models.APIKey, error)
+	Delete(ctx context.Context, login, id string) error
+}
+
+type apiKeyRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewAPIKeyRepository(db *pgxpool.Pool) APIKeyRepository {
+	return &apiKeyRepo{db: db}
+}
+
+const apiKeyColumns = `k.id, u.login, k.name, k.prefix, k.scopes, k.allowed_ips, k.expires_at, k.created_at, k.last_used_at`
+
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	var k models.APIKey
+	err := row.Scan(&k.ID, &k.Login, &k.Name, &k.Prefix, &k.Scopes, &k.AllowedIPs, &k.ExpiresAt, &k.CreatedAt, &k.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *apiKeyRepo) Create(ctx context.Context, k *models.APIKey, keyHash string) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO api_keys (user_id, name, key_hash, prefix, scopes, allowed_ips, expires_at)
+		SELECT id, $2, $3, $4, $5, $6, $7 FROM users WHERE login = $1
+		RETURNING id, created_at
+	`, k.Login, k.Name, keyHash, k.Prefix, k.Scopes, k.AllowedIPs, k.ExpiresAt).Scan(&k.ID, &k.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUnknownUser
+	}
+	return err
+}
+
+func (r *apiKeyRepo) GetByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	// last_used_at is only written once a minute, so busy keys do not turn
+	// every request into a write.
+	k, err := scanAPIKey(r.db.QueryRow(ctx, `
+		WITH touched AS (
+			UPDATE api_keys SET last_used_at = now()
+			WHERE key_hash = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')
+		)
+		SELECT `+apiKeyColumns+`
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1
+	`, keyHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.New("not found")
+	}
+	return k, err
+}
+
+func (r *apiKeyRepo) List(ctx context.Context, login string) ([]models.APIKey, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE u.login = $1
+		ORDER BY k.created_at DESC
+	`, login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+func (r *apiKeyRepo) Delete(ctx context.Context, login, id string) error {
+	tag, err := r.db.Exec(ctx, `
+		DELETE FROM api_keys k USING users u
+		WHERE k.user_id = u.id AND u.login = $1 AND k.id::text = $2
+	`, login, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("not found")
+	}
+	return nil
+}
@@ -2,31 +2,104 @@ package repository
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"time"
 	"web-server/internal/models"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// BlobCommitFunc publishes the staged file of a document. It runs inside the
+// insert transaction while the blobs row is locked; isNew reports whether
+// nothing else references the digest, a tombstone row included, so the file
+// must be published even if a copy is still stored.
+type BlobCommitFunc func(ctx context.Context, isNew bool) error
+
+// BlobReleaseFunc removes the stored file of d. It runs only once nothing
+// references that file any more, checked again after the delete or update
+// transaction has committed.
+type BlobReleaseFunc func(ctx context.Context, d *models.Document) error
+
+// ErrVersionConflict is returned by mutating calls given an expected version
+// (non-zero ifVersion) when the stored document has moved on.
+var ErrVersionConflict = errors.New("version conflict")
+
+// ErrUnknownUser and ErrUnknownGroup are returned when grants name a login
+// without an account or a group that does not exist.
+var (
+	ErrUnknownUser  = errors.New("unknown user")
+	ErrUnknownGroup = errors.New("unknown group")
+)
+
+// ErrNotInTrash is returned by Purge when the document is gone or was
+// restored meanwhile. It reads "not found" like the other lookups.
+var ErrNotInTrash = errors.New("not found")
+
 type DocumentRepository interface {
-	Upload(ctx context.Context, d *models.Document) error
-	List(ctx context.Context, viewer, key, value string, limit int) ([]models.Document, error)
+	Upload(ctx context.Context, d *models.Document, commitBlob BlobCommitFunc) error
+	// List returns the documents viewer may read, only those of owner if it
+	// is set.
+	List(ctx context.Context, viewer, owner, key, value string, limit int) ([]models.Document, error)
+	// GetByID and List skip documents in the trash.
 	GetByID(ctx context.Context, id string) (*models.Document, error)
-	Delete(ctx context.Context, id string) error
+	// GetWithAccess also returns what login may do with the document.
+	GetWithAccess(ctx context.Context, id, login string) (*models.Document, models.Access, error)
+	// AddGrant gives the user or group of g the role g.Role on document id,
+	// replacing the role it had. RevokeGrant fails with "not found" if there
+	// was no such grant. Both bump the version.
+	AddGrant(ctx context.Context, id string, g models.Grant, ifVersion int64) error
+	RevokeGrant(ctx context.Context, id string, g models.Grant, ifVersion int64) error
+	// Audience returns the owner and every user with access through a
+	// grant, directly or as a group member.
+	Audience(ctx context.Context, id string) ([]string, error)
+	// Trash moves a document to the trash and Restore takes it back.
+	Trash(ctx context.Context, id string, ifVersion int64) error
+	Restore(ctx context.Context, id string) error
+	GetTrashed(ctx context.Context, id string) (*models.Document, error)
+	ListTrash(ctx context.Context, owner string) ([]models.Document, error)
+	// ListTrashedBefore returns id and owner of documents trashed before t.
+	ListTrashedBefore(ctx context.Context, t time.Time) ([]models.Document, error)
+	// Purge removes a trashed document with its history. A document restored
+	// in the meantime is left alone and ErrNotInTrash returned.
+	Purge(ctx context.Context, id string, releaseBlob BlobReleaseFunc) error
+	// Update loads document id under the row lock, lets apply change it and
+	// stores the result with a bumped version, so changes made by others in
+	// the meantime are kept. Grants are only rewritten if apply changed
+	// them. If the file changed, the new digest is referenced and the
+	// previous file released.
+	Update(ctx context.Context, id string, ifVersion int64, apply func(d *models.Document) error, commitBlob BlobCommitFunc, releaseBlob BlobReleaseFunc) (*models.Document, error)
+	// PatchJSON replaces the JSON payload with apply(current) while holding
+	// the row lock, so concurrent patches never overwrite each other. It
+	// returns the new version.
+	PatchJSON(ctx context.Context, id string, ifVersion int64, apply func(doc []byte) ([]byte, error), releaseBlob BlobReleaseFunc) (int64, error)
+	// ListRevisions returns the archived versions of a document, newest
+	// first, without their JSON payload.
+	ListRevisions(ctx context.Context, id string) ([]models.Document, error)
+	GetRevision(ctx context.Context, id string, version int64) (*models.Document, error)
+	// ListFiles returns id, name and digest of every file document and
+	// archived revision.
+	ListFiles(ctx context.Context) ([]models.Document, error)
+	// ReapBlobs deletes the files of blobs left at refcount 0 together with
+	// their rows and returns how many went.
+	ReapBlobs(ctx context.Context, releaseBlob BlobReleaseFunc) (int, error)
 }
 
 type documentRepo struct {
 	db *pgxpool.Pool
+	// maxRevisions is the history length of documents without their own
+	// max_revisions.
+	maxRevisions int
 }
 
-func NewDocumentRepository(db *pgxpool.Pool) DocumentRepository {
-	return &documentRepo{db: db}
+func NewDocumentRepository(db *pgxpool.Pool, maxRevisions int) DocumentRepository {
+	return &documentRepo{db: db, maxRevisions: maxRevisions}
 }
 
-func (r *documentRepo) Upload(ctx context.Context, d *models.Document) error {
+func (r *documentRepo) Upload(ctx context.Context, d *models.Document, commitBlob BlobCommitFunc) error {
 	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		return err
@@ -37,33 +110,549 @@ func (r *documentRepo) Upload(ctx context.Context, d *models.Document) error {
 		}
 	}()
 
-	grantB, _ := json.Marshal(d.Grants)
 	_, err = tx.Exec(ctx, `
-		INSERT INTO documents (id, owner, name, mime, file, public, created_at, grants, json)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
-	`, d.ID, d.Owner, d.Name, d.Mime, d.File, d.Public, d.CreatedAt, grantB, d.JSONRaw)
-	return tx.Commit(ctx)
+		INSERT INTO documents (id, owner, name, mime, file, public, created_at, updated_at, json, filename)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$7,$8,$9)
+	`, d.ID, d.Owner, d.Name, d.Mime, d.File, d.Public, d.CreatedAt, d.JSONRaw, nullIfEmpty(d.Digest))
+	if err != nil {
+		return err
+	}
+	if err = setGrants(ctx, tx, d.ID, d.Grants); err != nil {
+		return err
+	}
+	if err = refBlob(ctx, tx, d, commitBlob); err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	return err
+}
+
+// readableIDsSQL selects the ids of the documents login, bound to the given
+// placeholder, may read. Each branch of the UNION is served by its own index
+// (idx_documents_owner, idx_documents_public, idx_document_grants_user_id and
+// idx_group_members_user_id), so List joins documents to a small id set
+// instead of testing every row against an OR. accessSQL holds the same rules
+// for the documents row aliased d.
+func readableIDsSQL(login string) string {
+	return fmt.Sprintf(`(
+		SELECT id FROM documents WHERE owner = %[1]s
+		UNION
+		SELECT id FROM documents WHERE public
+		UNION
+		SELECT g.document_id FROM document_grants g JOIN users u ON u.id = g.user_id
+		WHERE u.login = %[1]s
+		UNION
+		SELECT gg.document_id FROM group_members m JOIN users u ON u.id = m.user_id
+		JOIN document_group_grants gg ON gg.group_id = m.group_id
+		WHERE u.login = %[1]s)`, login)
+}
+
+func accessSQL(login string) string {
+	return fmt.Sprintf(`CASE WHEN d.owner = %[1]s THEN %[2]d ELSE GREATEST(
+		CASE WHEN d.public THEN %[3]d ELSE %[4]d END,
+		(SELECT %[5]s FROM document_grants g JOIN users u ON u.id = g.user_id
+		 WHERE g.document_id = d.id AND u.login = %[1]s),
+		(SELECT max(%[5]s) FROM document_group_grants g
+		 JOIN group_members m ON m.group_id = g.group_id JOIN users u ON u.id = m.user_id
+		 WHERE g.document_id = d.id AND u.login = %[1]s)) END`,
+		login, models.AccessOwner, models.AccessRead, models.AccessNone, roleAccessSQL("g.role"))
+}
+
+// roleAccessSQL is models.RoleAccess for a role column.
+func roleAccessSQL(col string) string {
+	return fmt.Sprintf(`CASE %s WHEN '%s' THEN %d WHEN '%s' THEN %d ELSE %d END`,
+		col, models.RoleManager, models.AccessManage, models.RoleEditor, models.AccessWrite, models.AccessRead)
+}
+
+// grantsSQL lists the grants of the documents row aliased d as JSON, user
+// grants first.
+const grantsSQL = `COALESCE((
+	SELECT jsonb_agg(x.item ORDER BY x.sort) FROM (
+		SELECT jsonb_build_object('login', u.login, 'role', g.role) AS item, '0' || u.login AS sort
+		FROM document_grants g JOIN users u ON u.id = g.user_id
+		WHERE g.document_id = d.id
+		UNION ALL
+		SELECT jsonb_build_object('group', gr.name, 'role', g.role), '1' || gr.name
+		FROM document_group_grants g JOIN groups gr ON gr.id = g.group_id
+		WHERE g.document_id = d.id
+	) x), '[]'::jsonb)`
+
+// setGrants makes grants the exact set of grants of document id. A login or
+// group listed twice gets the last role.
+func setGrants(ctx context.Context, tx pgx.Tx, id string, grants []models.Grant) error {
+	users, groups := map[string]string{}, map[string]string{}
+	for _, g := range grants {
+		if g.Group != "" {
+			groups[g.Group] = g.Role
+		} else {
+			users[g.Login] = g.Role
+		}
+	}
+	err := replaceGrants(ctx, tx, id, users, ErrUnknownUser,
+		`SELECT count(*) FROM users WHERE login = ANY($1)`,
+		`DELETE FROM document_grants g USING users u
+		 WHERE g.document_id = $1 AND g.user_id = u.id AND NOT (u.login = ANY($2))`,
+		`INSERT INTO document_grants (document_id, user_id, role)
+		 SELECT $1, u.id, n.role FROM unnest($2::text[], $3::text[]) AS n(name, role)
+		 JOIN users u ON u.login = n.name
+		 ON CONFLICT (document_id, user_id) DO UPDATE SET role = EXCLUDED.role`)
+	if err != nil {
+		return err
+	}
+	return replaceGrants(ctx, tx, id, groups, ErrUnknownGroup,
+		`SELECT count(*) FROM groups WHERE name = ANY($1)`,
+		`DELETE FROM document_group_grants g USING groups gr
+		 WHERE g.document_id = $1 AND g.group_id = gr.id AND NOT (gr.name = ANY($2))`,
+		`INSERT INTO document_group_grants (document_id, group_id, role)
+		 SELECT $1, gr.id, n.role FROM unnest($2::text[], $3::text[]) AS n(name, role)
+		 JOIN groups gr ON gr.name = n.name
+		 ON CONFLICT (document_id, group_id) DO UPDATE SET role = EXCLUDED.role`)
+}
+
+// replaceGrants runs the statements of setGrants for one kind of grantee;
+// roles maps grantee names to roles.
+func replaceGrants(ctx context.Context, tx pgx.Tx, id string, roles map[string]string, errUnknown error, countQ, deleteQ, insertQ string) error {
+	// Empty rather than nil slices: nil would be sent as NULL, which ANY()
+	// never matches.
+	names := append([]string{}, slices.Sorted(maps.Keys(roles))...)
+	roleList := make([]string, len(names))
+	for i, name := range names {
+		roleList[i] = roles[name]
+	}
+
+	var known int
+	if err := tx.QueryRow(ctx, countQ, names).Scan(&known); err != nil {
+		return err
+	}
+	if known != len(names) {
+		return errUnknown
+	}
+	if _, err := tx.Exec(ctx, deleteQ, id, names); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, insertQ, id, names, roleList)
+	return err
+}
+
+func (r *documentRepo) AddGrant(ctx context.Context, id string, g models.Grant, ifVersion int64) error {
+	q, name, errUnknown := `
+		INSERT INTO document_grants (document_id, user_id, role)
+		SELECT $1, id, $3 FROM users WHERE login = $2
+		ON CONFLICT (document_id, user_id) DO UPDATE SET role = EXCLUDED.role
+	`, g.Login, ErrUnknownUser
+	if g.Group != "" {
+		q, name, errUnknown = `
+			INSERT INTO document_group_grants (document_id, group_id, role)
+			SELECT $1, id, $3 FROM groups WHERE name = $2
+			ON CONFLICT (document_id, group_id) DO UPDATE SET role = EXCLUDED.role
+		`, g.Group, ErrUnknownGroup
+	}
+	return r.changeGrants(ctx, id, ifVersion, q, []any{id, name, g.Role}, errUnknown)
+}
+
+func (r *documentRepo) RevokeGrant(ctx context.Context, id string, g models.Grant, ifVersion int64) error {
+	q, name := `
+		DELETE FROM document_grants g USING users u
+		WHERE g.document_id = $1 AND g.user_id = u.id AND u.login = $2
+	`, g.Login
+	if g.Group != "" {
+		q, name = `
+			DELETE FROM document_group_grants g USING groups gr
+			WHERE g.document_id = $1 AND g.group_id = gr.id AND gr.name = $2
+		`, g.Group
+	}
+	return r.changeGrants(ctx, id, ifVersion, q, []any{id, name}, errors.New("not found"))
+}
+
+// changeGrants runs the grant statement q on the locked document id and bumps
+// its version, returning errNone if q touched no row.
+func (r *documentRepo) changeGrants(ctx context.Context, id string, ifVersion int64, q string, args []any, errNone error) error {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	var version int64
+	err = tx.QueryRow(ctx, `SELECT version FROM documents WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = errors.New("not found")
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if ifVersion != 0 && version != ifVersion {
+		err = ErrVersionConflict
+		return err
+	}
+	tag, err := tx.Exec(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		err = errNone
+		return err
+	}
+	if _, err = tx.Exec(ctx, `UPDATE documents SET version = version + 1, updated_at = now() WHERE id=$1`, id); err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	return err
+}
+
+func (r *documentRepo) Audience(ctx context.Context, id string) ([]string, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT owner FROM documents WHERE id = $1
+		UNION
+		SELECT u.login FROM document_grants g JOIN users u ON u.id = g.user_id
+		WHERE g.document_id = $1
+		UNION
+		SELECT u.login FROM document_group_grants g
+		JOIN group_members m ON m.group_id = g.group_id JOIN users u ON u.id = m.user_id
+		WHERE g.document_id = $1
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// refBlob adds a reference from d to its digest and lets commitBlob publish
+// the staged file while the blobs row is locked.
+func refBlob(ctx context.Context, tx pgx.Tx, d *models.Document, commitBlob BlobCommitFunc) error {
+	if d.Digest == "" {
+		return nil
+	}
+	var refcount int
+	err := tx.QueryRow(ctx, `
+		INSERT INTO blobs (digest, size, refcount) VALUES ($1,$2,1)
+		ON CONFLICT (digest) DO UPDATE SET refcount = blobs.refcount + 1
+		RETURNING refcount
+	`, d.Digest, d.Size).Scan(&refcount)
+	if err != nil {
+		return err
+	}
+	if commitBlob != nil {
+		return commitBlob(ctx, refcount == 1)
+	}
+	return nil
+}
+
+// unrefBlob drops the reference d held on its file, which must already be
+// gone from the documents table, and adds d to released if it was the last.
+// The blobs row stays behind at refcount 0 as a tombstone: the file is only
+// deleted by reapBlob, which rechecks the row under lock after commit.
+func unrefBlob(ctx context.Context, tx pgx.Tx, d *models.Document, released *[]models.Document) error {
+	last := false
+	switch {
+	case d.Digest != "":
+		var refcount int
+		err := tx.QueryRow(ctx, `
+			UPDATE blobs SET refcount = GREATEST(refcount - 1, 0) WHERE digest=$1 RETURNING refcount
+		`, d.Digest).Scan(&refcount)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Nothing counted this file; leave it to the orphan sweep.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		last = refcount == 0
+	case d.File && d.Name != "":
+		// Files stored before content addressing are keyed by name and may
+		// be shared by several documents and revisions with the same name.
+		others, err := legacyRefs(ctx, tx, d.Name)
+		if err != nil {
+			return err
+		}
+		last = others == 0
+	}
+	if last {
+		*released = append(*released, *d)
+	}
+	return nil
+}
+
+func legacyRefs(ctx context.Context, q pgx.Tx, name string) (int, error) {
+	var n int
+	err := q.QueryRow(ctx, `
+		SELECT (SELECT count(*) FROM documents WHERE file AND filename IS NULL AND name=$1)
+		     + (SELECT count(*) FROM document_revisions WHERE file AND filename IS NULL AND name=$1)
+	`, name).Scan(&n)
+	return n, err
+}
+
+// releaseAll reaps the files collected by unrefBlob. It must only run once
+// the transaction that dropped their references has committed. Files that
+// fail to go stay behind as tombstones for ReapBlobs.
+func (r *documentRepo) releaseAll(ctx context.Context, released []models.Document, releaseBlob BlobReleaseFunc) {
+	if releaseBlob == nil {
+		return
+	}
+	for i := range released {
+		_, _ = r.reapBlob(ctx, &released[i], releaseBlob)
+	}
+}
+
+// reapBlob deletes the file of d if it is still unreferenced. For content
+// addressed files the tombstone row is locked while the file goes, so an
+// upload of the same content either waits and then publishes its file anew,
+// or has already revived the row and the file is kept.
+func (r *documentRepo) reapBlob(ctx context.Context, d *models.Document, releaseBlob BlobReleaseFunc) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if d.Digest != "" {
+		var refcount int
+		err = tx.QueryRow(ctx, `SELECT refcount FROM blobs WHERE digest=$1 FOR UPDATE`, d.Digest).Scan(&refcount)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		if err != nil || refcount > 0 {
+			return false, err
+		}
+		if err = releaseBlob(ctx, d); err != nil {
+			return false, err
+		}
+		if _, err = tx.Exec(ctx, `DELETE FROM blobs WHERE digest=$1`, d.Digest); err != nil {
+			return false, err
+		}
+		return true, tx.Commit(ctx)
+	}
+	others, err := legacyRefs(ctx, tx, d.Name)
+	if err != nil || others > 0 {
+		return false, err
+	}
+	return true, releaseBlob(ctx, d)
+}
+
+func (r *documentRepo) ReapBlobs(ctx context.Context, releaseBlob BlobReleaseFunc) (int, error) {
+	rows, err := r.db.Query(ctx, `SELECT digest FROM blobs WHERE refcount = 0`)
+	if err != nil {
+		return 0, err
+	}
+	digests, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, digest := range digests {
+		reaped, err := r.reapBlob(ctx, &models.Document{File: true, Digest: digest}, releaseBlob)
+		if err != nil {
+			return n, err
+		}
+		if reaped {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// archive copies the current state of document id into its history. The
+// revision holds its own reference on the file, so replacing or deleting the
+// document keeps it readable.
+func archive(ctx context.Context, tx pgx.Tx, id string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO document_revisions (document_id, version, name, mime, file, json, filename, created_at)
+		SELECT id, version, name, mime, file, json, filename, COALESCE(updated_at, created_at)
+		FROM documents WHERE id=$1
+		ON CONFLICT (document_id, version) DO NOTHING
+	`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE blobs SET refcount = refcount + 1
+		WHERE digest = (SELECT filename FROM documents WHERE id=$1)
+	`, id)
+	return err
+}
+
+// pruneRevisions drops the history of document id beyond its limit, oldest
+// first, or all of it. Files left without references are added to released.
+func (r *documentRepo) pruneRevisions(ctx context.Context, tx pgx.Tx, id string, all bool, released *[]models.Document) error {
+	q := `
+		DELETE FROM document_revisions WHERE document_id=$1 AND version IN (
+			SELECT version FROM document_revisions WHERE document_id=$1
+			ORDER BY version DESC
+			OFFSET (SELECT COALESCE(max_revisions, $2) FROM documents WHERE id=$1)
+		)
+		RETURNING name, file, COALESCE(filename, '')`
+	args := []any{id, r.maxRevisions}
+	if all {
+		q = `DELETE FROM document_revisions WHERE document_id=$1 RETURNING name, file, COALESCE(filename, '')`
+		args = args[:1]
+	}
+	rows, err := tx.Query(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+	var dropped []models.Document
+	for rows.Next() {
+		d := models.Document{ID: id}
+		if err := rows.Scan(&d.Name, &d.File, &d.Digest); err != nil {
+			rows.Close()
+			return err
+		}
+		dropped = append(dropped, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range dropped {
+		if err := unrefBlob(ctx, tx, &dropped[i], released); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (r *documentRepo) GetByID(ctx context.Context, id string) (*models.Document, error) {
+	d, _, err := r.GetWithAccess(ctx, id, "")
+	return d, err
+}
+
+// documentColumns are the columns scanDocument reads, from documents d
+// left joined to blobs b.
+const documentColumns = `d.id, d.owner, d.name, d.mime, d.file, d.public, d.created_at,
+	COALESCE(d.updated_at, d.created_at), d.version, ` + grantsSQL + `, d.json,
+	COALESCE(d.filename, ''), COALESCE(b.size, 0), d.max_revisions`
+
+// scanDocument scans documentColumns followed by extra.
+func scanDocument(row pgx.Row, extra ...any) (*models.Document, error) {
 	var d models.Document
-	var grantRaw []byte
 	var jsonb []byte
+	dest := append([]any{&d.ID, &d.Owner, &d.Name, &d.Mime, &d.File, &d.Public, &d.CreatedAt, &d.UpdatedAt,
+		&d.Version, &d.Grants, &jsonb, &d.Digest, &d.Size, &d.MaxRevisions}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	d.JSONRaw = jsonb
+	return &d, nil
+}
+
+func (r *documentRepo) GetWithAccess(ctx context.Context, id, login string) (*models.Document, models.Access, error) {
+	var access models.Access
+	d, err := scanDocument(r.db.QueryRow(ctx, `
+		SELECT `+documentColumns+`, `+accessSQL("$2")+`
+		FROM documents d LEFT JOIN blobs b ON b.digest = d.filename
+		WHERE d.id=$1 AND d.deleted_at IS NULL
+	`, id, login), &access)
+	if err != nil {
+		return nil, models.AccessNone, err
+	}
+	return d, access, nil
+}
+
+func (r *documentRepo) Trash(ctx context.Context, id string, ifVersion int64) error {
+	var version int64
 	err := r.db.QueryRow(ctx, `
-		SELECT id, owner, name, mime, file, public, created_at, grants, json
-		FROM documents WHERE id=$1
-	`, id).Scan(&d.ID, &d.Owner, &d.Name, &d.Mime, &d.File, &d.Public, &d.CreatedAt, &grantRaw, &jsonb)
+		UPDATE documents SET deleted_at = now()
+		WHERE id=$1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)
+		RETURNING version
+	`, id, ifVersion).Scan(&version)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	err = r.db.QueryRow(ctx, `SELECT version FROM documents WHERE id=$1 AND deleted_at IS NULL`, id).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("not found")
+	}
 	if err != nil {
-		return nil, err
+		return err
+	}
+	return ErrVersionConflict
+}
+
+func (r *documentRepo) Restore(ctx context.Context, id string) error {
+	tag, err := r.db.Exec(ctx, `UPDATE documents SET deleted_at = NULL WHERE id=$1 AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return err
 	}
-	if len(grantRaw) > 0 {
-		_ = json.Unmarshal(grantRaw, &d.Grants)
+	if tag.RowsAffected() == 0 {
+		return errors.New("not found")
+	}
+	return nil
+}
+
+const trashColumns = `id, owner, name, mime, file, public, created_at, COALESCE(updated_at, created_at), version, deleted_at`
+
+func scanTrashed(row pgx.Row) (*models.Document, error) {
+	var d models.Document
+	err := row.Scan(&d.ID, &d.Owner, &d.Name, &d.Mime, &d.File, &d.Public, &d.CreatedAt, &d.UpdatedAt, &d.Version, &d.DeletedAt)
+	if err != nil {
+		return nil, err
 	}
-	d.JSONRaw = jsonb
 	return &d, nil
 }
 
-func (r *documentRepo) Delete(ctx context.Context, id string) error {
+func (r *documentRepo) GetTrashed(ctx context.Context, id string) (*models.Document, error) {
+	d, err := scanTrashed(r.db.QueryRow(ctx, `
+		SELECT `+trashColumns+` FROM documents WHERE id=$1 AND deleted_at IS NOT NULL
+	`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.New("not found")
+	}
+	return d, err
+}
+
+func (r *documentRepo) ListTrash(ctx context.Context, owner string) ([]models.Document, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+trashColumns+` FROM documents
+		WHERE owner=$1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC
+	`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []models.Document{}
+	for rows.Next() {
+		d, err := scanTrashed(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *d)
+	}
+	return out, rows.Err()
+}
+
+func (r *documentRepo) ListTrashedBefore(ctx context.Context, t time.Time) ([]models.Document, error) {
+	rows, err := r.db.Query(ctx, `SELECT id, owner FROM documents WHERE deleted_at < $1`, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Document
+	for rows.Next() {
+		var d models.Document
+		if err := rows.Scan(&d.ID, &d.Owner); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (r *documentRepo) Purge(ctx context.Context, id string, releaseBlob BlobReleaseFunc) error {
 	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		return err
@@ -73,30 +662,245 @@ func (r *documentRepo) Delete(ctx context.Context, id string) error {
 			tx.Rollback(ctx)
 		}
 	}()
-	cmd, err := tx.Exec(ctx, `DELETE FROM documents WHERE id=$1`, id)
+	var d models.Document
+	err = tx.QueryRow(ctx, `
+		SELECT id, name, file, COALESCE(filename, '') FROM documents
+		WHERE id=$1 AND deleted_at IS NOT NULL FOR UPDATE
+	`, id).Scan(&d.ID, &d.Name, &d.File, &d.Digest)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrNotInTrash
+		return err
+	}
 	if err != nil {
 		return err
 	}
-	if cmd.RowsAffected() == 0 {
-		tx.Rollback(ctx)
-		return errors.New("not found")
+
+	// The history goes first: its rows would otherwise cascade away without
+	// releasing their files.
+	var released []models.Document
+	if err = r.pruneRevisions(ctx, tx, id, true, &released); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM documents WHERE id=$1`, id); err != nil {
+		return err
+	}
+	if err = unrefBlob(ctx, tx, &d, &released); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	r.releaseAll(ctx, released, releaseBlob)
+	return nil
+}
+
+func (r *documentRepo) Update(ctx context.Context, id string, ifVersion int64, apply func(d *models.Document) error, commitBlob BlobCommitFunc, releaseBlob BlobReleaseFunc) (*models.Document, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	old, err := scanDocument(tx.QueryRow(ctx, `
+		SELECT `+documentColumns+`
+		FROM documents d LEFT JOIN blobs b ON b.digest = d.filename
+		WHERE d.id=$1 AND d.deleted_at IS NULL
+		FOR UPDATE OF d
+	`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = errors.New("not found")
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ifVersion != 0 && old.Version != ifVersion {
+		err = ErrVersionConflict
+		return nil, err
+	}
+	d := *old
+	d.Grants = slices.Clone(old.Grants)
+	if err = apply(&d); err != nil {
+		return nil, err
 	}
-	return tx.Commit(ctx)
+
+	if err = archive(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	err = tx.QueryRow(ctx, `
+		UPDATE documents
+		SET name=$2, mime=$3, public=$4, json=$5, filename=$6, updated_at=$7,
+		    max_revisions=$8, version = version + 1
+		WHERE id=$1
+		RETURNING version
+	`, id, d.Name, d.Mime, d.Public, d.JSONRaw, nullIfEmpty(d.Digest), d.UpdatedAt, d.MaxRevisions).Scan(&d.Version)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Equal(d.Grants, old.Grants) {
+		if err = setGrants(ctx, tx, id, d.Grants); err != nil {
+			return nil, err
+		}
+	}
+	var released []models.Document
+	if d.Digest != old.Digest {
+		if err = refBlob(ctx, tx, &d, commitBlob); err != nil {
+			return nil, err
+		}
+		if err = unrefBlob(ctx, tx, old, &released); err != nil {
+			return nil, err
+		}
+	} else if commitBlob != nil {
+		// Same content as before: the staged copy is not needed.
+		if err = commitBlob(ctx, false); err != nil {
+			return nil, err
+		}
+	}
+	if err = r.pruneRevisions(ctx, tx, id, false, &released); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	r.releaseAll(ctx, released, releaseBlob)
+	return &d, nil
 }
 
-func (r *documentRepo) List(ctx context.Context, viewer, key, value string, limit int) ([]models.Document, error) {
+func (r *documentRepo) PatchJSON(ctx context.Context, id string, ifVersion int64, apply func(doc []byte) ([]byte, error), releaseBlob BlobReleaseFunc) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	var cur []byte
+	var version int64
+	err = tx.QueryRow(ctx, `SELECT json, version FROM documents WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&cur, &version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errors.New("not found")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if ifVersion != 0 && version != ifVersion {
+		err = ErrVersionConflict
+		return 0, err
+	}
+	next, err := apply(cur)
+	if err != nil {
+		return 0, err
+	}
+	if err = archive(ctx, tx, id); err != nil {
+		return 0, err
+	}
+	err = tx.QueryRow(ctx, `
+		UPDATE documents SET json=$2, updated_at=now(), version = version + 1
+		WHERE id=$1 RETURNING version
+	`, id, next).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	var released []models.Document
+	if err = r.pruneRevisions(ctx, tx, id, false, &released); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	r.releaseAll(ctx, released, releaseBlob)
+	return version, nil
+}
+
+func (r *documentRepo) ListRevisions(ctx context.Context, id string) ([]models.Document, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT v.version, v.name, v.mime, v.file, v.created_at, COALESCE(v.filename, ''), COALESCE(b.size, 0)
+		FROM document_revisions v LEFT JOIN blobs b ON b.digest = v.filename
+		WHERE v.document_id=$1
+		ORDER BY v.version DESC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []models.Document{}
+	for rows.Next() {
+		d := models.Document{ID: id}
+		if err := rows.Scan(&d.Version, &d.Name, &d.Mime, &d.File, &d.UpdatedAt, &d.Digest, &d.Size); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (r *documentRepo) GetRevision(ctx context.Context, id string, version int64) (*models.Document, error) {
+	d := models.Document{ID: id, Version: version}
+	err := r.db.QueryRow(ctx, `
+		SELECT v.name, v.mime, v.file, v.created_at, v.json, COALESCE(v.filename, ''), COALESCE(b.size, 0)
+		FROM document_revisions v LEFT JOIN blobs b ON b.digest = v.filename
+		WHERE v.document_id=$1 AND v.version=$2
+	`, id, version).Scan(&d.Name, &d.Mime, &d.File, &d.UpdatedAt, &d.JSONRaw, &d.Digest, &d.Size)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.New("not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (r *documentRepo) ListFiles(ctx context.Context) ([]models.Document, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, name, COALESCE(filename, '') FROM documents WHERE file = true
+		UNION ALL
+		SELECT document_id, name, COALESCE(filename, '') FROM document_revisions WHERE file = true
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Document
+	for rows.Next() {
+		d := models.Document{File: true}
+		if err := rows.Scan(&d.ID, &d.Name, &d.Digest); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (r *documentRepo) List(ctx context.Context, viewer, owner, key, value string, limit int) ([]models.Document, error) {
 	q := `
-        SELECT id, owner, name, mime, file, public, created_at, grants, json
-        FROM documents
-        WHERE (owner = $1 OR $1 = ANY (SELECT jsonb_array_elements_text(grants)) OR public = true)
+        SELECT d.id, d.owner, d.name, d.mime, d.file, d.public, d.created_at, COALESCE(d.updated_at, d.created_at),
+               d.version, ` + grantsSQL + `, d.json, COALESCE(d.filename, '')
+        FROM ` + readableIDsSQL("$1") + ` r
+        JOIN documents d ON d.id = r.id
+        WHERE d.deleted_at IS NULL
     `
 	args := []interface{}{viewer}
 	i := 2
 
+	if owner != "" {
+		q += fmt.Sprintf(" AND d.owner = $%d", i)
+		args = append(args, owner)
+		i++
+	}
+
 	if key != "" && value != "" {
 		switch key {
 		case "name", "mime":
-			q += fmt.Sprintf(" AND %s = $%d", key, i)
+			q += fmt.Sprintf(" AND d.%s = $%d", key, i)
 			args = append(args, value)
 			i++
 		case "file":
@@ -104,7 +908,7 @@ func (r *documentRepo) List(ctx context.Context, viewer, key, value string, limi
 			if value == "true" {
 				b = true
 			}
-			q += fmt.Sprintf(" AND file = $%d", i)
+			q += fmt.Sprintf(" AND d.file = $%d", i)
 			args = append(args, b)
 			i++
 		case "public":
@@ -112,14 +916,14 @@ func (r *documentRepo) List(ctx context.Context, viewer, key, value string, limi
 			if value == "true" {
 				b = true
 			}
-			q += fmt.Sprintf(" AND public = $%d", i)
+			q += fmt.Sprintf(" AND d.public = $%d", i)
 			args = append(args, b)
 			i++
 		default:
 		}
 	}
 
-	q += " ORDER BY name ASC, created_at DESC"
+	q += " ORDER BY d.name ASC, d.created_at DESC"
 
 	if limit > 0 {
 		q += fmt.Sprintf(" LIMIT %d", limit)
@@ -134,14 +938,10 @@ func (r *documentRepo) List(ctx context.Context, viewer, key, value string, limi
 	var out []models.Document
 	for rows.Next() {
 		var d models.Document
-		var grantRaw []byte
 		var jsonb []byte
-		if err := rows.Scan(&d.ID, &d.Owner, &d.Name, &d.Mime, &d.File, &d.Public, &d.CreatedAt, &grantRaw, &jsonb); err != nil {
+		if err := rows.Scan(&d.ID, &d.Owner, &d.Name, &d.Mime, &d.File, &d.Public, &d.CreatedAt, &d.UpdatedAt, &d.Version, &d.Grants, &jsonb, &d.Digest); err != nil {
 			return nil, err
 		}
-		if len(grantRaw) > 0 {
-			_ = json.Unmarshal(grantRaw, &d.Grants)
-		}
 		d.JSONRaw = jsonb
 		out = append(out, d)
 	}
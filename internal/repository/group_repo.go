@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"web-server/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrGroupExists   = errors.New("group exists")
+	ErrGroupNotFound = errors.New("group not found")
+)
+
+type GroupRepository interface {
+	// Create makes a group owned by, and initially containing, owner.
+	Create(ctx context.Context, name, owner string) (*models.Group, error)
+	Get(ctx context.Context, name string) (*models.Group, error)
+	// ListForUser returns the groups login owns or belongs to.
+	ListForUser(ctx context.Context, login string) ([]models.Group, error)
+	AddMember(ctx context.Context, name, login string) error
+	RemoveMember(ctx context.Context, name, login string) error
+	Delete(ctx context.Context, name string) error
+}
+
+type groupRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewGroupRepository(db *pgxpool.Pool) GroupRepository {
+	return &groupRepo{db: db}
+}
+
+const groupSelect = `
+	SELECT gr.id, gr.name, o.login, gr.created_at,
+	       ARRAY(SELECT u.login FROM group_members m JOIN users u ON u.id = m.user_id
+	             WHERE m.group_id = gr.id ORDER BY u.login)
+	FROM groups gr JOIN users o ON o.id = gr.owner_id`
+
+func scanGroup(row pgx.Row) (*models.Group, error) {
+	var g models.Group
+	if err := row.Scan(&g.ID, &g.Name, &g.Owner, &g.CreatedAt, &g.Members); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func (r *groupRepo) Create(ctx context.Context, name, owner string) (*models.Group, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id string
+	err = tx.QueryRow(ctx, `
+		INSERT INTO groups (name, owner_id) SELECT $1, id FROM users WHERE login = $2
+		RETURNING id
+	`, name, owner).Scan(&id)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrGroupExists
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUnknownUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO group_members (group_id, user_id) SELECT $1, owner_id FROM groups WHERE id = $1
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, name)
+}
+
+func (r *groupRepo) Get(ctx context.Context, name string) (*models.Group, error) {
+	g, err := scanGroup(r.db.QueryRow(ctx, groupSelect+` WHERE gr.name = $1`, name))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrGroupNotFound
+	}
+	return g, err
+}
+
+func (r *groupRepo) ListForUser(ctx context.Context, login string) ([]models.Group, error) {
+	rows, err := r.db.Query(ctx, groupSelect+`
+		WHERE o.login = $1 OR gr.id IN (
+			SELECT m.group_id FROM group_members m JOIN users u ON u.id = m.user_id WHERE u.login = $1)
+		ORDER BY gr.name
+	`, login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []models.Group{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *g)
+	}
+	return out, rows.Err()
+}
+
+func (r *groupRepo) AddMember(ctx context.Context, name, login string) error {
+	tag, err := r.db.Exec(ctx, `
+		INSERT INTO group_members (group_id, user_id)
+		SELECT gr.id, u.id FROM groups gr, users u WHERE gr.name = $1 AND u.login = $2
+		ON CONFLICT DO NOTHING
+	`, name, login)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		err := r.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE login = $1)`, login).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUnknownUser
+		}
+	}
+	return nil
+}
+
+func (r *groupRepo) RemoveMember(ctx context.Context, name, login string) error {
+	tag, err := r.db.Exec(ctx, `
+		DELETE FROM group_members m USING groups gr, users u
+		WHERE m.group_id = gr.id AND m.user_id = u.id AND gr.name = $1 AND u.login = $2
+	`, name, login)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("not found")
+	}
+	return nil
+}
+
+func (r *groupRepo) Delete(ctx context.Context, name string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM groups WHERE name = $1`, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrGroupNotFound
+	}
+	return nil
+}
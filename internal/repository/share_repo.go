@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"web-server/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrLinkExhausted is returned by Consume when the link has expired or
+// reached its download limit.
+var ErrLinkExhausted = errors.New("link exhausted")
+
+type ShareRepository interface {
+	// Create stores l, filling in its id and creation time.
+	Create(ctx context.Context, l *models.ShareLink, tokenHash string) error
+	GetByToken(ctx context.Context, tokenHash string) (*models.ShareLink, error)
+	ListByDocument(ctx context.Context, docID string) ([]models.ShareLink, error)
+	Delete(ctx context.Context, docID, id string) error
+	// Consume counts one download, unless that would exceed the limit.
+	Consume(ctx context.Context, id string) error
+}
+
+type shareRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewShareRepository(db *pgxpool.Pool) ShareRepository {
+	return &shareRepo{db: db}
+}
+
+const shareSelect = `
+	SELECT id, document_id, created_by, created_at, expires_at, COALESCE(password_hash, ''),
+	       max_downloads, downloads
+	FROM share_links`
+
+func scanShareLink(row pgx.Row) (*models.ShareLink, error) {
+	var l models.ShareLink
+	err := row.Scan(&l.ID, &l.DocumentID, &l.CreatedBy, &l.CreatedAt, &l.ExpiresAt, &l.PasswordHash, &l.MaxDownloads, &l.Downloads)
+	if err != nil {
+		return nil, err
+	}
+	l.HasPassword = l.PasswordHash != ""
+	return &l, nil
+}
+
+func (r *shareRepo) Create(ctx context.Context, l *models.ShareLink, tokenHash string) error {
+	return r.db.QueryRow(ctx, `
+		INSERT INTO share_links (token_hash, document_id, created_by, expires_at, password_hash, max_downloads)
+		VALUES ($1,$2,$3,$4,$5,$6)
+		RETURNING id, created_at
+	`, tokenHash, l.DocumentID, l.CreatedBy, l.ExpiresAt, nullIfEmpty(l.PasswordHash), l.MaxDownloads).Scan(&l.ID, &l.CreatedAt)
+}
+
+func (r *shareRepo) GetByToken(ctx context.Context, tokenHash string) (*models.ShareLink, error) {
+	l, err := scanShareLink(r.db.QueryRow(ctx, shareSelect+` WHERE token_hash = $1`, tokenHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.New("not found")
+	}
+	return l, err
+}
+
+func (r *shareRepo) ListByDocument(ctx context.Context, docID string) ([]models.ShareLink, error) {
+	rows, err := r.db.Query(ctx, shareSelect+` WHERE document_id = $1 ORDER BY created_at DESC`, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []models.ShareLink{}
+	for rows.Next() {
+		l, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *l)
+	}
+	return out, rows.Err()
+}
+
+func (r *shareRepo) Delete(ctx context.Context, docID, id string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM share_links WHERE document_id = $1 AND id::text = $2`, docID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("not found")
+	}
+	return nil
+}
+
+func (r *shareRepo) Consume(ctx context.Context, id string) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE share_links SET downloads = downloads + 1
+		WHERE id = $1 AND expires_at > now() AND (max_downloads IS NULL OR downloads < max_downloads)
+	`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLinkExhausted
+	}
+	return nil
+}
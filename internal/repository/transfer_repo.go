@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"web-server/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type TransferRepository interface {
+	// Transfer makes to the owner of document id and records it as done by
+	// actor. The new owner's own grant becomes redundant and is dropped;
+	// keep, if not empty, is the role the previous owner is granted.
+	Transfer(ctx context.Context, id, to, actor, keep string, ifVersion int64) (*models.Transfer, error)
+	// TransferAll does the same for every document of from, trashed ones
+	// included, in one transaction.
+	TransferAll(ctx context.Context, from, to, actor, keep string) ([]models.Transfer, error)
+	// List returns the transfers login took part in, as either owner or
+	// actor, newest first. An empty login lists all of them.
+	List(ctx context.Context, login string) ([]models.Transfer, error)
+}
+
+type transferRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewTransferRepository(db *pgxpool.Pool) TransferRepository {
+	return &transferRepo{db: db}
+}
+
+func (r *transferRepo) Transfer(ctx context.Context, id, to, actor, keep string, ifVersion int64) (t *models.Transfer, err error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	var owner string
+	var version int64
+	err = tx.QueryRow(ctx, `
+		SELECT owner, version FROM documents WHERE id=$1 AND deleted_at IS NULL FOR UPDATE
+	`, id).Scan(&owner, &version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.New("not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ifVersion != 0 && version != ifVersion {
+		return nil, ErrVersionConflict
+	}
+	toID, err := userID(ctx, tx, to)
+	if err != nil {
+		return nil, err
+	}
+	t, err = transferTx(ctx, tx, id, owner, to, toID, actor, keep)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit(ctx)
+	return t, err
+}
+
+func (r *transferRepo) TransferAll(ctx context.Context, from, to, actor, keep string) (ts []models.Transfer, err error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	toID, err := userID(ctx, tx, to)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, `SELECT id FROM documents WHERE owner=$1 ORDER BY id FOR UPDATE`, from)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	ts = []models.Transfer{}
+	for _, id := range ids {
+		var t *models.Transfer
+		t, err = transferTx(ctx, tx, id, from, to, toID, actor, keep)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, *t)
+	}
+	err = tx.Commit(ctx)
+	return ts, err
+}
+
+func userID(ctx context.Context, tx pgx.Tx, login string) (string, error) {
+	var id string
+	err := tx.QueryRow(ctx, `SELECT id FROM users WHERE login=$1`, login).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrUnknownUser
+	}
+	return id, err
+}
+
+// transferTx moves the locked document id from owner from to the user to
+// with id toID. Like any other change it bumps the version, so stale
+// If-Match requests fail afterwards.
+func transferTx(ctx context.Context, tx pgx.Tx, id, from, to, toID, actor, keep string) (*models.Transfer, error) {
+	if _, err := tx.Exec(ctx, `
+		UPDATE documents SET owner=$2, version = version + 1, updated_at = now() WHERE id=$1
+	`, id, to); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM document_grants WHERE document_id=$1 AND user_id=$2`, id, toID); err != nil {
+		return nil, err
+	}
+	if keep != "" {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO document_grants (document_id, user_id, role)
+			SELECT $1, id, $3 FROM users WHERE login = $2
+			ON CONFLICT (document_id, user_id) DO UPDATE SET role = EXCLUDED.role
+		`, id, from, keep)
+		if err != nil {
+			return nil, err
+		}
+	}
+	t := &models.Transfer{DocumentID: id, From: from, To: to, Actor: actor}
+	err := tx.QueryRow(ctx, `
+		INSERT INTO document_transfers (document_id, from_owner, to_owner, actor)
+		VALUES ($1,$2,$3,$4)
+		RETURNING id, created_at
+	`, id, from, to, actor).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (r *transferRepo) List(ctx context.Context, login string) ([]models.Transfer, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, document_id, from_owner, to_owner, actor, created_at
+		FROM document_transfers
+		WHERE $1 = '' OR from_owner = $1 OR to_owner = $1 OR actor = $1
+		ORDER BY id DESC
+	`, login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ts := []models.Transfer{}
+	for rows.Next() {
+		var t models.Transfer
+		if err := rows.Scan(&t.ID, &t.DocumentID, &t.From, &t.To, &t.Actor, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, rows.Err()
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+	"web-server/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrOffsetConflict = errors.New("offset conflict")
+	ErrUploadClaimed  = errors.New("upload already completing")
+)
+
+type UploadRepository interface {
+	Create(ctx context.Context, u *models.Upload) error
+	Get(ctx context.Context, id string) (*models.Upload, error)
+	// Advance moves the offset from `from` to `to` and records the part,
+	// failing with ErrOffsetConflict if another request got there first.
+	Advance(ctx context.Context, id string, from, to int64, part string) error
+	// Claim marks a complete upload as being assembled, failing with
+	// ErrUploadClaimed if another request holds it. Unclaim hands it back
+	// after a failed attempt.
+	Claim(ctx context.Context, id string) error
+	Unclaim(ctx context.Context, id string) error
+	// Delete removes an upload nobody is completing; a claimed one fails
+	// with ErrUploadClaimed. Complete removes the upload its claimer has
+	// turned into a document.
+	Delete(ctx context.Context, id string) error
+	Complete(ctx context.Context, id string) error
+	// ListExpired skips claimed uploads.
+	ListExpired(ctx context.Context, now time.Time) ([]models.Upload, error)
+}
+
+type uploadRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewUploadRepository(db *pgxpool.Pool) UploadRepository {
+	return &uploadRepo{db: db}
+}
+
+func (r *uploadRepo) Create(ctx context.Context, u *models.Upload) error {
+	metaB, _ := json.Marshal(u.Meta)
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO uploads (id, owner, length, "offset", meta, json, created_at, expires_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+	`, u.ID, u.Owner, u.Length, u.Offset, metaB, u.JSONRaw, u.CreatedAt, u.ExpiresAt)
+	return err
+}
+
+const uploadColumns = `id, owner, length, "offset", meta, json, parts, created_at, expires_at`
+
+func scanUpload(row pgx.Row) (*models.Upload, error) {
+	var u models.Upload
+	var metaRaw, partsRaw []byte
+	if err := row.Scan(&u.ID, &u.Owner, &u.Length, &u.Offset, &metaRaw, &u.JSONRaw, &partsRaw, &u.CreatedAt, &u.ExpiresAt); err != nil {
+		return nil, err
+	}
+	_ = json.Unmarshal(metaRaw, &u.Meta)
+	_ = json.Unmarshal(partsRaw, &u.Parts)
+	return &u, nil
+}
+
+func (r *uploadRepo) Get(ctx context.Context, id string) (*models.Upload, error) {
+	u, err := scanUpload(r.db.QueryRow(ctx, `SELECT `+uploadColumns+` FROM uploads WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.New("not found")
+	}
+	return u, err
+}
+
+func (r *uploadRepo) Advance(ctx context.Context, id string, from, to int64, part string) error {
+	partB, _ := json.Marshal([]string{part})
+	cmd, err := r.db.Exec(ctx, `
+		UPDATE uploads SET "offset"=$3, parts = parts || $4::jsonb
+		WHERE id=$1 AND "offset"=$2
+	`, id, from, to, partB)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrOffsetConflict
+	}
+	return nil
+}
+
+func (r *uploadRepo) Claim(ctx context.Context, id string) error {
+	cmd, err := r.db.Exec(ctx, `
+		UPDATE uploads SET completing = true
+		WHERE id=$1 AND "offset" = length AND NOT completing
+	`, id)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrUploadClaimed
+	}
+	return nil
+}
+
+func (r *uploadRepo) Unclaim(ctx context.Context, id string) error {
+	_, err := r.db.Exec(ctx, `UPDATE uploads SET completing = false WHERE id=$1`, id)
+	return err
+}
+
+func (r *uploadRepo) Delete(ctx context.Context, id string) error {
+	cmd, err := r.db.Exec(ctx, `DELETE FROM uploads WHERE id=$1 AND NOT completing`, id)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() > 0 {
+		return nil
+	}
+	err = r.db.QueryRow(ctx, `SELECT 1 FROM uploads WHERE id=$1`, id).Scan(new(int))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("not found")
+	}
+	if err != nil {
+		return err
+	}
+	return ErrUploadClaimed
+}
+
+func (r *uploadRepo) Complete(ctx context.Context, id string) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM uploads WHERE id=$1 AND completing`, id)
+	return err
+}
+
+func (r *uploadRepo) ListExpired(ctx context.Context, now time.Time) ([]models.Upload, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+uploadColumns+` FROM uploads WHERE expires_at < $1 AND NOT completing`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Upload
+	for rows.Next() {
+		u, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *u)
+	}
+	return out, rows.Err()
+}
@@ -6,17 +6,49 @@ import (
 	"time"
 	"web-server/internal/models"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ErrRefreshReused is returned by RotateRefreshToken for a refresh token
+// that was already rotated out; the session it belonged to is deleted.
+var ErrRefreshReused = errors.New("refresh token reused")
+
 type UserRepository interface {
 	Create(ctx context.Context, login, hash string) error
 	GetByLogin(ctx context.Context, login string) (*models.User, error)
-	CreateSession(ctx context.Context, token, userID string, expires time.Time) error
+	// CreateSession stores a new session expiring at s.ExpiresAt. UserAgent
+	// and IP of s describe the client; refreshHash, if set, is the hash of
+	// its refresh token, valid until s.RefreshExpiresAt.
+	CreateSession(ctx context.Context, token, refreshHash, userID string, s models.Session) error
+	// RotateRefreshToken replaces the access and refresh token of the
+	// session whose refresh token hashes to refreshHash. The access token
+	// expires at expires, or with the session if that is sooner. It returns
+	// the replaced access token, the login and the new expiry; on
+	// ErrRefreshReused the token returned is that of the revoked session.
+	RotateRefreshToken(ctx context.Context, refreshHash, newToken, newRefreshHash string, expires time.Time) (string, string, time.Time, error)
+	// ExtendSession moves the expiry of an access token to expires, but
+	// not past the end of its session, and returns the new expiry.
+	ExtendSession(ctx context.Context, token string, expires time.Time) (time.Time, error)
 
-	GetLoginByToken(ctx context.Context, token string) (string, error)
+	// GetLoginByToken returns the login of a live session and when it
+	// expires.
+	GetLoginByToken(ctx context.Context, token string) (string, time.Time, error)
 	DeleteSession(ctx context.Context, token string) error
-	GetActiveToken(ctx context.Context, userID string) (string, error)
+	// ListSessions returns the live sessions of login, newest first, with
+	// their tokens.
+	ListSessions(ctx context.Context, login string) ([]models.Session, []string, error)
+	// DeleteSessionByID removes one session of login and returns its token.
+	DeleteSessionByID(ctx context.Context, login, id string) (string, error)
+	// DeleteOtherSessions removes every session of login except keepToken
+	// and returns the removed tokens.
+	DeleteOtherSessions(ctx context.Context, login, keepToken string) ([]string, error)
+	// RevokeTokens lists signed token ids as revoked until expires.
+	RevokeTokens(ctx context.Context, ids []string, expires time.Time) error
+	// ListRevokedTokens drops revocations that have run out and returns the
+	// rest with their expiry.
+	ListRevokedTokens(ctx context.Context) (map[string]time.Time, error)
+	IsTokenRevoked(ctx context.Context, id string) (bool, error)
 }
 
 type userRepo struct {
@@ -43,30 +75,103 @@ func (r *userRepo) GetByLogin(ctx context.Context, login string) (*models.User,
 	return &u, nil
 }
 
-func (r *userRepo) CreateSession(ctx context.Context, token, userID string, expires time.Time) error {
+func (r *userRepo) CreateSession(ctx context.Context, token, refreshHash, userID string, s models.Session) error {
 	_, err := r.db.Exec(ctx,
-		`INSERT INTO sessions (token,user_id,expires_at) VALUES ($1,$2,$3)`,
-		token, userID, expires)
+		`INSERT INTO sessions (token,user_id,expires_at,user_agent,ip,refresh_hash,refresh_expires_at) VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+		token, userID, s.ExpiresAt, s.UserAgent, s.IP, nullIfEmpty(refreshHash), s.RefreshExpiresAt)
 	return err
 }
 
-func (r *userRepo) GetLoginByToken(ctx context.Context, token string) (string, error) {
+func (r *userRepo) RotateRefreshToken(ctx context.Context, refreshHash, newToken, newRefreshHash string, expires time.Time) (oldToken, login string, newExpires time.Time, err error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	defer func() {
+		if err != nil && !errors.Is(err, ErrRefreshReused) {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	var id string
+	var refreshExpires time.Time
+	err = tx.QueryRow(ctx, `
+        SELECT s.id, s.token, s.refresh_expires_at, u.login
+        FROM sessions s
+        JOIN users u ON u.id = s.user_id
+        WHERE s.refresh_hash=$1
+        FOR UPDATE OF s
+    `, refreshHash).Scan(&id, &oldToken, &refreshExpires, &login)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// A rotated-out token: revoke the family it came from.
+		err = tx.QueryRow(ctx, `
+            DELETE FROM sessions WHERE id = (SELECT session_id FROM used_refresh_tokens WHERE token_hash=$1)
+            RETURNING token
+        `, refreshHash).Scan(&oldToken)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", "", time.Time{}, errors.New("not found")
+		}
+		if err != nil {
+			return "", "", time.Time{}, err
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return "", "", time.Time{}, err
+		}
+		return oldToken, "", time.Time{}, ErrRefreshReused
+	}
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	if time.Now().After(refreshExpires) {
+		return "", "", time.Time{}, errors.New("session expired")
+	}
+	if _, err = tx.Exec(ctx, `
+        INSERT INTO used_refresh_tokens (token_hash, session_id) VALUES ($1,$2)
+    `, refreshHash, id); err != nil {
+		return "", "", time.Time{}, err
+	}
+	err = tx.QueryRow(ctx, `
+        UPDATE sessions SET token=$2, refresh_hash=$3, expires_at=LEAST($4, refresh_expires_at)
+        WHERE id=$1
+        RETURNING expires_at
+    `, id, newToken, newRefreshHash, expires).Scan(&newExpires)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	err = tx.Commit(ctx)
+	return oldToken, login, newExpires, err
+}
+
+func (r *userRepo) ExtendSession(ctx context.Context, token string, expires time.Time) (time.Time, error) {
+	err := r.db.QueryRow(ctx, `
+        UPDATE sessions SET expires_at = LEAST($2, COALESCE(refresh_expires_at, $2))
+        WHERE token=$1 AND expires_at < $2
+        RETURNING expires_at
+    `, token, expires).Scan(&expires)
+	return expires, err
+}
+
+func (r *userRepo) GetLoginByToken(ctx context.Context, token string) (string, time.Time, error) {
 	var login string
 	var expires time.Time
+	var refreshExpires *time.Time
 	err := r.db.QueryRow(ctx, `
-        SELECT u.login, s.expires_at
+        SELECT u.login, s.expires_at, s.refresh_expires_at
         FROM sessions s
         JOIN users u ON u.id = s.user_id
         WHERE s.token = $1
-    `, token).Scan(&login, &expires)
+    `, token).Scan(&login, &expires, &refreshExpires)
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 	if time.Now().After(expires) {
-		_, _ = r.db.Exec(ctx, `DELETE FROM sessions WHERE token=$1`, token)
-		return "", errors.New("session expired")
+		// The session outlives its access token while it can be refreshed.
+		if refreshExpires == nil || time.Now().After(*refreshExpires) {
+			_, _ = r.db.Exec(ctx, `DELETE FROM sessions WHERE token=$1`, token)
+		}
+		return "", time.Time{}, errors.New("session expired")
 	}
-	return login, nil
+	return login, expires, nil
 }
 
 func (r *userRepo) DeleteSession(ctx context.Context, token string) error {
@@ -80,18 +185,94 @@ func (r *userRepo) DeleteSession(ctx context.Context, token string) error {
 	return nil
 }
 
-func (r *userRepo) GetActiveToken(ctx context.Context, userID string) (string, error) {
+func (r *userRepo) ListSessions(ctx context.Context, login string) ([]models.Session, []string, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT s.id, COALESCE(s.user_agent, ''), COALESCE(s.ip, ''), s.created_at, s.expires_at,
+               s.refresh_expires_at, s.token
+        FROM sessions s
+        JOIN users u ON u.id = s.user_id
+        WHERE u.login = $1 AND (s.expires_at > NOW() OR s.refresh_expires_at > NOW())
+        ORDER BY s.created_at DESC
+    `, login)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	var tokens []string
+	for rows.Next() {
+		var s models.Session
+		var token string
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.ExpiresAt, &s.RefreshExpiresAt, &token); err != nil {
+			return nil, nil, err
+		}
+		sessions = append(sessions, s)
+		tokens = append(tokens, token)
+	}
+	return sessions, tokens, rows.Err()
+}
+
+func (r *userRepo) DeleteSessionByID(ctx context.Context, login, id string) (string, error) {
 	var token string
-	var expires time.Time
 	err := r.db.QueryRow(ctx, `
-        SELECT token, expires_at 
-        FROM sessions 
-        WHERE user_id=$1 AND expires_at > NOW()
-        ORDER BY expires_at DESC
-        LIMIT 1
-    `, userID).Scan(&token, &expires)
+        DELETE FROM sessions s USING users u
+        WHERE s.user_id = u.id AND u.login = $1 AND s.id::text = $2
+        RETURNING s.token
+    `, login, id).Scan(&token)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", errors.New("not found")
+	}
+	return token, err
+}
+
+func (r *userRepo) DeleteOtherSessions(ctx context.Context, login, keepToken string) ([]string, error) {
+	rows, err := r.db.Query(ctx, `
+        DELETE FROM sessions s USING users u
+        WHERE s.user_id = u.id AND u.login = $1 AND s.token <> $2
+        RETURNING s.token
+    `, login, keepToken)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return token, nil
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (r *userRepo) RevokeTokens(ctx context.Context, ids []string, expires time.Time) error {
+	_, err := r.db.Exec(ctx, `
+        INSERT INTO revoked_tokens (jti, expires_at)
+        SELECT unnest($1::text[]), $2
+        ON CONFLICT (jti) DO NOTHING
+    `, ids, expires)
+	return err
+}
+
+func (r *userRepo) ListRevokedTokens(ctx context.Context) (map[string]time.Time, error) {
+	if _, err := r.db.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at < NOW()`); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.Query(ctx, `SELECT jti, expires_at FROM revoked_tokens`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+	for rows.Next() {
+		var id string
+		var expires time.Time
+		if err := rows.Scan(&id, &expires); err != nil {
+			return nil, err
+		}
+		revoked[id] = expires
+	}
+	return revoked, rows.Err()
+}
+
+func (r *userRepo) IsTokenRevoked(ctx context.Context, id string) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRow(ctx, `
+        SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1 AND expires_at >= NOW())
+    `, id).Scan(&revoked)
+	return revoked, err
 }
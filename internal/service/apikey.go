@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+	"web-server/internal/models"
+	"web-server/internal/util"
+)
+
+// apiKeyPrefix marks API keys, so they can share the Authorization header
+// with session tokens.
+const apiKeyPrefix = "dk_"
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	ErrAPIKeyOptions = errors.New("invalid api key options")
+	ErrScopeDenied   = errors.New("api key lacks scope")
+	ErrIPNotAllowed  = errors.New("ip not allowed for api key")
+)
+
+// APIKeyOptions describe a new API key. Scopes must not be empty; no
+// expiry and no allowed IPs mean the key never expires and works from
+// anywhere.
+type APIKeyOptions struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires"`
+	AllowedIPs []string   `json:"allowed_ips"`
+}
+
+var knownScopes = []string{models.ScopeDocsRead, models.ScopeDocsWrite, models.ScopeDocsDelete}
+
+func validateAPIKey(opts APIKeyOptions) error {
+	if opts.Name == "" || len(opts.Name) > 64 || len(opts.Scopes) == 0 {
+		return ErrAPIKeyOptions
+	}
+	for _, sc := range opts.Scopes {
+		if !slices.Contains(knownScopes, sc) {
+			return ErrAPIKeyOptions
+		}
+	}
+	if opts.ExpiresAt != nil && !opts.ExpiresAt.After(time.Now()) {
+		return ErrAPIKeyOptions
+	}
+	for _, ip := range opts.AllowedIPs {
+		if _, err := parseIPRange(ip); err != nil {
+			return ErrAPIKeyOptions
+		}
+	}
+	return nil
+}
+
+// parseIPRange accepts a single address or a CIDR range.
+func parseIPRange(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	return netip.ParsePrefix(s)
+}
+
+func ipAllowed(allowed []string, ip string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, a := range allowed {
+		if p, err := parseIPRange(a); err == nil && p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *userService) CreateAPIKey(ctx context.Context, login string, opts APIKeyOptions) (*models.APIKey, string, error) {
+	if err := validateAPIKey(opts); err != nil {
+		return nil, "", err
+	}
+	secret, err := util.NewToken()
+	if err != nil {
+		return nil, "", err
+	}
+	key := apiKeyPrefix + secret
+	if opts.AllowedIPs == nil {
+		opts.AllowedIPs = []string{}
+	}
+	k := &models.APIKey{
+		Login:      login,
+		Name:       opts.Name,
+		Prefix:     key[:len(apiKeyPrefix)+8],
+		Scopes:     slices.Compact(slices.Sorted(slices.Values(opts.Scopes))),
+		AllowedIPs: opts.AllowedIPs,
+		ExpiresAt:  opts.ExpiresAt,
+	}
+	if err := s.keys.Create(ctx, k, util.HashToken(key)); err != nil {
+		return nil, "", err
+	}
+	return k, key, nil
+}
+
+func (s *userService) ListAPIKeys(ctx context.Context, login string) ([]models.APIKey, error) {
+	return s.keys.List(ctx, login)
+}
+
+func (s *userService) RevokeAPIKey(ctx context.Context, login, id string) error {
+	return s.keys.Delete(ctx, login, id)
+}
+
+func (s *userService) Authorize(ctx context.Context, token, ip, scope string) (string, error) {
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return s.ValidateToken(ctx, token)
+	}
+	k, err := s.keys.GetByHash(ctx, util.HashToken(token))
+	if err != nil {
+		if err.Error() == "not found" {
+			return "", ErrInvalidAPIKey
+		}
+		return "", err
+	}
+	if k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt) {
+		return "", ErrInvalidAPIKey
+	}
+	if !slices.Contains(k.Scopes, scope) {
+		return "", ErrScopeDenied
+	}
+	if !ipAllowed(k.AllowedIPs, ip) {
+		return "", ErrIPNotAllowed
+	}
+	return k.Login, nil
+}
@@ -2,42 +2,290 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"path/filepath"
+	"io"
+	"slices"
 	"time"
 	"web-server/internal/models"
 	"web-server/internal/repository"
+	"web-server/internal/storage"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
 type DocumentService interface {
-	CreateDocument(ctx context.Context, owner string, meta models.DocumentMeta, jsonData map[string]any) (string, error)
+	CreateDocument(ctx context.Context, owner string, meta models.DocumentMeta, jsonData map[string]any, file io.Reader) (string, error)
 	ListDocuments(ctx context.Context, requester, login, key, value string, limit int) ([]models.Document, error)
-	GetDocument(ctx context.Context, requester, id string) (*models.Document, string, string, map[string]any, error)
-	DeleteDocument(ctx context.Context, requester, id string) error
+	GetDocument(ctx context.Context, requester, id string) (*models.Document, io.ReadSeekCloser, string, map[string]any, error)
+	// The mutating methods take the versions listed in If-Match; nil means
+	// no precondition. They fail with ErrPreconditionFailed if the document
+	// is at a different version.
+	// DeleteDocument moves the document to the owner's trash.
+	DeleteDocument(ctx context.Context, requester, id string, ifMatch []int64) error
+	// UpdateDocument changes metadata (PATCH), ReplaceDocument the JSON
+	// payload and file contents (PUT). Both keep the document id.
+	UpdateDocument(ctx context.Context, requester, id string, patch models.DocumentPatch, ifMatch []int64) (*models.Document, error)
+	ReplaceDocument(ctx context.Context, requester, id string, jsonData map[string]any, file io.Reader, ifMatch []int64) (*models.Document, error)
+	// PatchJSON applies an RFC 6902 JSON Patch or an RFC 7396 JSON Merge
+	// Patch, selected by contentType, to the JSON payload.
+	PatchJSON(ctx context.Context, requester, id, contentType string, patch []byte, ifMatch []int64) (*models.Document, error)
+	// ListRevisions returns the current version followed by the archived
+	// ones, newest first.
+	ListRevisions(ctx context.Context, requester, id string) ([]models.Document, error)
+	GetRevision(ctx context.Context, requester, id string, version int64) (*models.Document, io.ReadSeekCloser, map[string]any, error)
+	// DiffRevisions returns the JSON Merge Patch turning the payload of
+	// version from into that of version to, 0 meaning the current one, and
+	// the version compared against.
+	DiffRevisions(ctx context.Context, requester, id string, from, to int64) ([]byte, int64, error)
+	// RestoreRevision makes the content of an archived version current
+	// again, as a new version.
+	RestoreRevision(ctx context.Context, requester, id string, version int64, ifMatch []int64) (*models.Document, error)
+
+	// ListGrants is open to anyone who can read the document; AddGrant and
+	// RevokeGrant need the manager role.
+	ListGrants(ctx context.Context, requester, id string) ([]models.Grant, error)
+	AddGrant(ctx context.Context, requester, id string, g models.Grant, ifMatch []int64) ([]models.Grant, error)
+	RevokeGrant(ctx context.Context, requester, id string, g models.Grant, ifMatch []int64) ([]models.Grant, error)
+
+	ListTrash(ctx context.Context, requester string) ([]models.Document, error)
+	RestoreDocument(ctx context.Context, requester, id string) (*models.Document, error)
+	// PurgeDocument deletes a trashed document for good, together with its
+	// history and files no other document refers to.
+	PurgeDocument(ctx context.Context, requester, id string) error
+	EmptyTrash(ctx context.Context, requester string) (int, error)
+	// PurgeTrash purges documents trashed before t, for every user.
+	PurgeTrash(ctx context.Context, t time.Time) (int, error)
+}
+
+var ErrPreconditionFailed = repository.ErrVersionConflict
+
+// ErrUnknownUser and ErrUnknownGroup mean grants name a login that has no
+// account or a group that does not exist.
+var (
+	ErrUnknownUser  = repository.ErrUnknownUser
+	ErrUnknownGroup = repository.ErrUnknownGroup
+)
+
+// ErrInvalidGrant means a grant has no login or an unknown role.
+var ErrInvalidGrant = errors.New("invalid grant")
+
+// ErrNotGroupMember means grants add a group the granter does not belong to.
+var ErrNotGroupMember = errors.New("not a group member")
+
+// precondition checks d against If-Match and returns the version the write
+// must be conditional on, so a concurrent change is caught too.
+func precondition(d *models.Document, ifMatch []int64) (int64, error) {
+	if ifMatch == nil {
+		return 0, nil
+	}
+	if !slices.Contains(ifMatch, d.Version) {
+		return 0, ErrPreconditionFailed
+	}
+	return d.Version, nil
 }
 
+const (
+	ContentTypeJSONPatch  = "application/json-patch+json"
+	ContentTypeMergePatch = "application/merge-patch+json"
+)
+
+var (
+	ErrRevisionNotFound   = errors.New("revision not found")
+	ErrInvalidPatch       = errors.New("invalid patch")
+	ErrPatchTestFailed    = errors.New("patch test failed")
+	ErrPatchNotApplicable = errors.New("patch does not apply")
+)
+
 type documentService struct {
-	repo       repository.DocumentRepository
-	cache      *redis.Client
-	ttl        time.Duration
-	storageDir string
+	repo   repository.DocumentRepository
+	groups repository.GroupRepository
+	cache  *redis.Client
+	ttl    time.Duration
+	blobs  storage.BlobStore
+}
+
+func NewDocumentService(repo repository.DocumentRepository, groups repository.GroupRepository, cache *redis.Client, ttl time.Duration, blobs storage.BlobStore) DocumentService {
+	return &documentService{repo: repo, groups: groups, cache: cache, ttl: ttl, blobs: blobs}
+}
+
+func cacheKey(viewer, owner, key, value string, limit int) string {
+	return fmt.Sprintf("docs:%s:%s:%s:%s:%d", viewer, owner, key, value, limit)
+}
+
+// blobKey spreads content-addressed blobs over 256 directories.
+func blobKey(digest string) string {
+	return "sha256/" + digest[:2] + "/" + digest
+}
+
+// fileKey returns the storage key of a file document. Documents uploaded
+// before content addressing have no digest and are stored under their name.
+func fileKey(d *models.Document) string {
+	if d.Digest != "" {
+		return blobKey(d.Digest)
+	}
+	return d.Name
+}
+
+// stagedBlob is a file written under a temporary key. It is moved under its
+// SHA-256 digest by the document transaction, so a failed insert never
+// leaves a blob behind and a failed move never leaves a row pointing at
+// nothing. A nil *stagedBlob stands for "no file".
+type stagedBlob struct {
+	blobs  storage.BlobStore
+	tmp    string
+	digest string
+	size   int64
+	moved  bool
+}
+
+// stageBlob streams r into a temporary object while hashing it.
+func (s *documentService) stageBlob(ctx context.Context, r io.Reader) (*stagedBlob, error) {
+	b := &stagedBlob{blobs: s.blobs, tmp: "tmp/" + uuid.NewString()}
+	h := sha256.New()
+	size, err := s.blobs.Put(ctx, b.tmp, io.TeeReader(r, h))
+	if err != nil {
+		_ = s.blobs.Delete(ctx, b.tmp)
+		return nil, err
+	}
+	b.digest, b.size = hex.EncodeToString(h.Sum(nil)), size
+	return b, nil
+}
+
+func (b *stagedBlob) commitFunc() repository.BlobCommitFunc {
+	if b == nil {
+		return nil
+	}
+	return func(ctx context.Context, isNew bool) error {
+		if !isNew {
+			_ = b.blobs.Delete(ctx, b.tmp)
+			return nil
+		}
+		if err := b.blobs.Move(ctx, b.tmp, blobKey(b.digest)); err != nil {
+			return err
+		}
+		b.moved = true
+		return nil
+	}
+}
+
+// abort cleans up after a failed transaction.
+func (b *stagedBlob) abort(ctx context.Context) {
+	if b == nil {
+		return
+	}
+	_ = b.blobs.Delete(ctx, b.tmp)
+	if b.moved {
+		_ = b.blobs.Delete(ctx, blobKey(b.digest))
+	}
+}
+
+func (s *documentService) releaseBlob(ctx context.Context, d *models.Document) error {
+	if err := s.blobs.Delete(ctx, fileKey(d)); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// audience lists the users whose document lists include d, group members
+// and anonymous visitors included.
+func (s *documentService) audience(ctx context.Context, d *models.Document) []string {
+	logins, err := s.repo.Audience(ctx, d.ID)
+	if err != nil {
+		logins = []string{d.Owner}
+	}
+	if d.Public {
+		// Anonymous visitors list public documents under the empty login.
+		logins = append(logins, "")
+	}
+	return logins
+}
+
+func validateGrants(grants []models.Grant) error {
+	for _, g := range grants {
+		if (g.Login == "") == (g.Group == "") || models.RoleAccess(g.Role) == models.AccessNone {
+			return ErrInvalidGrant
+		}
+	}
+	return nil
+}
+
+// memberGroups returns the groups granter belongs to if grants name any
+// group, nil otherwise.
+func (s *documentService) memberGroups(ctx context.Context, granter string, grants []models.Grant) (map[string]bool, error) {
+	if !slices.ContainsFunc(grants, func(g models.Grant) bool { return g.Group != "" }) {
+		return nil, nil
+	}
+	groups, err := s.groups.ListForUser(ctx, granter)
+	if err != nil {
+		return nil, err
+	}
+	member := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		member[g.Name] = true
+	}
+	return member, nil
 }
 
-func NewDocumentService(repo repository.DocumentRepository, cache *redis.Client, ttl time.Duration, storageDir string) DocumentService {
-	return &documentService{repo: repo, cache: cache, ttl: ttl, storageDir: storageDir}
+// checkGroupGrants fails with ErrNotGroupMember if grants add a group,
+// one not already granted in current, outside of member. Groups granted by
+// someone else may be kept or have their role changed.
+func checkGroupGrants(grants, current []models.Grant, member map[string]bool) error {
+	for _, g := range grants {
+		if g.Group == "" || member[g.Group] {
+			continue
+		}
+		if !slices.ContainsFunc(current, func(c models.Grant) bool { return c.Group == g.Group }) {
+			return ErrNotGroupMember
+		}
+	}
+	return nil
 }
 
-func cacheKey(viewer, key, value string, limit int) string {
-	return fmt.Sprintf("docs:%s:%s:%s:%d", viewer, key, value, limit)
+func (s *documentService) invalidate(ctx context.Context, logins ...string) {
+	invalidateLists(ctx, s.cache, logins...)
+}
+
+// invalidateLists drops the cached document lists of logins.
+func invalidateLists(ctx context.Context, cache *redis.Client, logins ...string) {
+	for _, login := range logins {
+		pattern := fmt.Sprintf("docs:%s:*", login)
+		keys, _ := cache.Keys(ctx, pattern).Result()
+		if len(keys) > 0 {
+			_, _ = cache.Del(ctx, keys...).Result()
+		}
+	}
 }
 
-func (s *documentService) CreateDocument(ctx context.Context, owner string, meta models.DocumentMeta, jsonData map[string]any) (string, error) {
+func (s *documentService) CreateDocument(ctx context.Context, owner string, meta models.DocumentMeta, jsonData map[string]any, file io.Reader) (string, error) {
+	if err := validateGrants(meta.Grants); err != nil {
+		return "", err
+	}
+	member, err := s.memberGroups(ctx, owner, meta.Grants)
+	if err != nil {
+		return "", err
+	}
+	if err := checkGroupGrants(meta.Grants, nil, member); err != nil {
+		return "", err
+	}
+	var staged *stagedBlob
+	if meta.File {
+		if file == nil {
+			return "", errors.New("file required")
+		}
+		var err error
+		staged, err = s.stageBlob(ctx, file)
+		if err != nil {
+			return "", err
+		}
+	}
 	id := uuid.NewString()
+	now := time.Now()
 	doc := &models.Document{
 		ID:        id,
 		Owner:     owner,
@@ -45,73 +293,106 @@ func (s *documentService) CreateDocument(ctx context.Context, owner string, meta
 		Mime:      meta.Mime,
 		File:      meta.File,
 		Public:    meta.Public,
-		CreatedAt: time.Now(),
+		CreatedAt: now,
+		UpdatedAt: now,
 		Grants:    meta.Grants,
 		JSONRaw:   nil,
 	}
+	if staged != nil {
+		doc.Digest, doc.Size = staged.digest, staged.size
+	}
 	if jsonData != nil {
 		if b, err := json.Marshal(jsonData); err == nil {
 			doc.JSONRaw = b
 		}
 	}
-	if err := s.repo.Upload(ctx, doc); err != nil {
+
+	if err := s.repo.Upload(ctx, doc, staged.commitFunc()); err != nil {
+		staged.abort(ctx)
 		return "", err
 	}
-	pattern := fmt.Sprintf("docs:%s:*", owner)
-	keys, _ := s.cache.Keys(ctx, pattern).Result()
-	if len(keys) > 0 {
-		_, _ = s.cache.Del(ctx, keys...).Result()
-	}
+	s.invalidate(ctx, s.audience(ctx, doc)...)
 	return id, nil
 }
 
 func (s *documentService) ListDocuments(ctx context.Context, requester, login, key, value string, limit int) ([]models.Document, error) {
-	viewer := requester
-	if login != "" {
-		viewer = login
+	// login only narrows the list to that owner's documents; what is listed
+	// is always decided by the requester's own access.
+	viewer, owner := requester, ""
+	if requester != "" {
+		owner = login
 	}
-	k := cacheKey(viewer, key, value, limit)
+	k := cacheKey(viewer, owner, key, value, limit)
 	if val, err := s.cache.Get(ctx, k).Result(); err == nil {
 		var docs []models.Document
 		if json.Unmarshal([]byte(val), &docs) == nil {
 			return docs, nil
 		}
 	}
-	docs, err := s.repo.List(ctx, viewer, key, value, limit)
+	docs, err := s.repo.List(ctx, viewer, owner, key, value, limit)
 	if err != nil {
 		return nil, err
 	}
+	if viewer == "" {
+		// Who a public document is shared with is not public.
+		for i := range docs {
+			docs[i].Grants = []models.Grant{}
+		}
+	}
 	if b, err := json.Marshal(docs); err == nil {
 		_ = s.cache.Set(ctx, k, b, s.ttl).Err()
 	}
 	return docs, nil
 }
 
-func (s *documentService) GetDocument(ctx context.Context, requester, id string) (*models.Document, string, string, map[string]any, error) {
-	d, err := s.repo.GetByID(ctx, id)
+// authorize loads document id and checks that requester has at least the
+// access need. The rules themselves live in the repository query, next to
+// the list filter.
+func (s *documentService) authorize(ctx context.Context, requester, id string, need models.Access) (*models.Document, error) {
+	return authorizeDocument(ctx, s.repo, requester, id, need)
+}
+
+func authorizeDocument(ctx context.Context, repo repository.DocumentRepository, requester, id string, need models.Access) (*models.Document, error) {
+	d, access, err := repo.GetWithAccess(ctx, id, requester)
 	if err != nil {
-		return nil, "", "", nil, err
+		return nil, err
 	}
-	allowed := false
-	if d.Owner == requester {
-		allowed = true
+	if access < need {
+		return nil, errors.New("forbidden")
 	}
-	if d.Public {
-		allowed = true
+	return d, nil
+}
+
+func (s *documentService) open(ctx context.Context, d *models.Document) (io.ReadSeekCloser, error) {
+	return openFile(ctx, s.blobs, d)
+}
+
+// openFile returns a reader over the file of d, or nil if it has none.
+func openFile(ctx context.Context, blobs storage.BlobStore, d *models.Document) (io.ReadSeekCloser, error) {
+	if !d.File || fileKey(d) == "" {
+		return nil, nil
 	}
-	for _, g := range d.Grants {
-		if g == requester {
-			allowed = true
-			break
+	if d.Digest == "" {
+		info, err := blobs.Stat(ctx, fileKey(d))
+		if err != nil {
+			return nil, err
 		}
+		d.Size = info.Size
 	}
-	if !allowed {
-		return nil, "", "", nil, errors.New("forbidden")
-	}
+	return storage.NewReader(ctx, blobs, fileKey(d), d.Size), nil
+}
 
-	filePath := ""
-	if d.File && d.Name != "" {
-		filePath = filepath.Join(s.storageDir, d.Name)
+func (s *documentService) GetDocument(ctx context.Context, requester, id string) (*models.Document, io.ReadSeekCloser, string, map[string]any, error) {
+	d, err := s.authorize(ctx, requester, id, models.AccessRead)
+	if err != nil {
+		return nil, nil, "", nil, err
+	}
+	if requester == "" {
+		d.Grants = []models.Grant{}
+	}
+	file, err := s.open(ctx, d)
+	if err != nil {
+		return nil, nil, "", nil, err
 	}
 
 	var jsonData map[string]any
@@ -119,24 +400,423 @@ func (s *documentService) GetDocument(ctx context.Context, requester, id string)
 		_ = json.Unmarshal(d.JSONRaw, &jsonData)
 	}
 
-	return d, filePath, d.Mime, jsonData, nil
+	return d, file, d.Mime, jsonData, nil
 }
 
-func (s *documentService) DeleteDocument(ctx context.Context, requester, id string) error {
-	d, err := s.repo.GetByID(ctx, id)
+func (s *documentService) DeleteDocument(ctx context.Context, requester, id string, ifMatch []int64) error {
+	d, err := s.authorize(ctx, requester, id, models.AccessOwner)
 	if err != nil {
 		return err
 	}
+	ifVersion, err := precondition(d, ifMatch)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Trash(ctx, id, ifVersion); err != nil {
+		return err
+	}
+	s.invalidate(ctx, s.audience(ctx, d)...)
+	return nil
+}
+
+func (s *documentService) UpdateDocument(ctx context.Context, requester, id string, patch models.DocumentPatch, ifMatch []int64) (*models.Document, error) {
+	// Editors may rename; who sees the document is up to managers.
+	need := models.AccessWrite
+	if patch.Public != nil || patch.Grants != nil || patch.MaxRevisions != nil {
+		need = models.AccessManage
+	}
+	d, err := s.authorize(ctx, requester, id, need)
+	if err != nil {
+		return nil, err
+	}
+	before := s.audience(ctx, d)
+	ifVersion, err := precondition(d, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	var member map[string]bool
+	if patch.Grants != nil {
+		if err := validateGrants(*patch.Grants); err != nil {
+			return nil, err
+		}
+		if member, err = s.memberGroups(ctx, requester, *patch.Grants); err != nil {
+			return nil, err
+		}
+	}
+	if patch.MaxRevisions != nil && *patch.MaxRevisions < 0 {
+		return nil, errors.New("invalid max_revisions")
+	}
+	// Only the fields in the patch are applied, to the row as it is under
+	// the lock, so concurrent changes to the other fields are kept.
+	d, err = s.repo.Update(ctx, id, ifVersion, func(d *models.Document) error {
+		if patch.Name != nil && *patch.Name != d.Name {
+			if d.File && d.Digest == "" {
+				// Such files are stored under their name; re-upload with PUT first.
+				return errors.New("legacy file cannot be renamed")
+			}
+			d.Name = *patch.Name
+		}
+		if patch.Mime != nil {
+			d.Mime = *patch.Mime
+		}
+		if patch.Public != nil {
+			d.Public = *patch.Public
+		}
+		if patch.Grants != nil {
+			if err := checkGroupGrants(*patch.Grants, d.Grants, member); err != nil {
+				return err
+			}
+			d.Grants = *patch.Grants
+		}
+		if patch.MaxRevisions != nil {
+			d.MaxRevisions = patch.MaxRevisions
+		}
+		d.UpdatedAt = time.Now()
+		return nil
+	}, nil, s.releaseBlob)
+	if err != nil {
+		return nil, err
+	}
+	s.invalidate(ctx, append(before, s.audience(ctx, d)...)...)
+	return d, nil
+}
+
+func (s *documentService) ReplaceDocument(ctx context.Context, requester, id string, jsonData map[string]any, file io.Reader, ifMatch []int64) (*models.Document, error) {
+	d, err := s.authorize(ctx, requester, id, models.AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+	ifVersion, err := precondition(d, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	var staged *stagedBlob
+	if d.File {
+		if file == nil {
+			return nil, errors.New("file required")
+		}
+		staged, err = s.stageBlob(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var jsonRaw []byte
+	if jsonData != nil {
+		if b, err := json.Marshal(jsonData); err == nil {
+			jsonRaw = b
+		}
+	}
+	d, err = s.repo.Update(ctx, id, ifVersion, func(d *models.Document) error {
+		if staged != nil {
+			d.Digest, d.Size = staged.digest, staged.size
+		}
+		d.JSONRaw = jsonRaw
+		d.UpdatedAt = time.Now()
+		return nil
+	}, staged.commitFunc(), s.releaseBlob)
+	if err != nil {
+		staged.abort(ctx)
+		return nil, err
+	}
+	s.invalidate(ctx, s.audience(ctx, d)...)
+	return d, nil
+}
+
+func (s *documentService) PatchJSON(ctx context.Context, requester, id, contentType string, patch []byte, ifMatch []int64) (*models.Document, error) {
+	d, err := s.authorize(ctx, requester, id, models.AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+	ifVersion, err := precondition(d, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	var apply func(doc []byte) ([]byte, error)
+	switch contentType {
+	case ContentTypeJSONPatch:
+		p, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, ErrInvalidPatch
+		}
+		apply = p.Apply
+	case ContentTypeMergePatch:
+		if !json.Valid(patch) {
+			return nil, ErrInvalidPatch
+		}
+		apply = func(doc []byte) ([]byte, error) {
+			return jsonpatch.MergePatch(doc, patch)
+		}
+	default:
+		return nil, ErrInvalidPatch
+	}
+
+	var next []byte
+	version, err := s.repo.PatchJSON(ctx, id, ifVersion, func(doc []byte) ([]byte, error) {
+		if len(doc) == 0 {
+			doc = []byte("{}")
+		}
+		var err error
+		next, err = apply(doc)
+		switch {
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			return nil, ErrPatchTestFailed
+		case err != nil:
+			return nil, ErrPatchNotApplicable
+		}
+		// The payload must stay a JSON object.
+		var obj map[string]any
+		if err := json.Unmarshal(next, &obj); err != nil || obj == nil {
+			return nil, ErrPatchNotApplicable
+		}
+		return next, nil
+	}, s.releaseBlob)
+	if err != nil {
+		return nil, err
+	}
+	d.JSONRaw, d.Version, d.UpdatedAt = next, version, time.Now()
+	s.invalidate(ctx, s.audience(ctx, d)...)
+	return d, nil
+}
+
+func (s *documentService) ListRevisions(ctx context.Context, requester, id string) ([]models.Document, error) {
+	d, err := s.authorize(ctx, requester, id, models.AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	revs, err := s.repo.ListRevisions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	d.JSONRaw = nil
+	return append([]models.Document{*d}, revs...), nil
+}
+
+// revision returns version of d, which may be d itself.
+func (s *documentService) revision(ctx context.Context, d *models.Document, version int64) (*models.Document, error) {
+	if version == d.Version {
+		return d, nil
+	}
+	rev, err := s.repo.GetRevision(ctx, d.ID, version)
+	if err != nil {
+		return nil, ErrRevisionNotFound
+	}
+	rev.Owner, rev.Public, rev.Grants, rev.CreatedAt = d.Owner, d.Public, d.Grants, d.CreatedAt
+	return rev, nil
+}
+
+func (s *documentService) GetRevision(ctx context.Context, requester, id string, version int64) (*models.Document, io.ReadSeekCloser, map[string]any, error) {
+	d, err := s.authorize(ctx, requester, id, models.AccessRead)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rev, err := s.revision(ctx, d, version)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	file, err := s.open(ctx, rev)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var jsonData map[string]any
+	if len(rev.JSONRaw) > 0 {
+		_ = json.Unmarshal(rev.JSONRaw, &jsonData)
+	}
+	return rev, file, jsonData, nil
+}
+
+func (s *documentService) DiffRevisions(ctx context.Context, requester, id string, from, to int64) ([]byte, int64, error) {
+	d, err := s.authorize(ctx, requester, id, models.AccessRead)
+	if err != nil {
+		return nil, 0, err
+	}
+	if to == 0 {
+		to = d.Version
+	}
+	payload := func(version int64) ([]byte, error) {
+		rev, err := s.revision(ctx, d, version)
+		if err != nil {
+			return nil, err
+		}
+		if len(rev.JSONRaw) == 0 {
+			return []byte("{}"), nil
+		}
+		return rev.JSONRaw, nil
+	}
+	a, err := payload(from)
+	if err != nil {
+		return nil, 0, err
+	}
+	b, err := payload(to)
+	if err != nil {
+		return nil, 0, err
+	}
+	diff, err := jsonpatch.CreateMergePatch(a, b)
+	return diff, to, err
+}
+
+func (s *documentService) RestoreRevision(ctx context.Context, requester, id string, version int64, ifMatch []int64) (*models.Document, error) {
+	d, err := s.authorize(ctx, requester, id, models.AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+	ifVersion, err := precondition(d, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := s.repo.GetRevision(ctx, id, version)
+	if err != nil {
+		return nil, ErrRevisionNotFound
+	}
+	// The archived revision still references its file, so nothing needs to
+	// be staged.
+	d, err = s.repo.Update(ctx, id, ifVersion, func(d *models.Document) error {
+		d.Name, d.Mime, d.JSONRaw = rev.Name, rev.Mime, rev.JSONRaw
+		d.Digest, d.Size = rev.Digest, rev.Size
+		d.UpdatedAt = time.Now()
+		return nil
+	}, nil, s.releaseBlob)
+	if err != nil {
+		return nil, err
+	}
+	s.invalidate(ctx, s.audience(ctx, d)...)
+	return d, nil
+}
+
+func (s *documentService) ListTrash(ctx context.Context, requester string) ([]models.Document, error) {
+	return s.repo.ListTrash(ctx, requester)
+}
+
+// trashed loads a document from the trash of requester.
+func (s *documentService) trashed(ctx context.Context, requester, id string) (*models.Document, error) {
+	d, err := s.repo.GetTrashed(ctx, id)
+	if err != nil {
+		return nil, err
+	}
 	if d.Owner != requester {
-		return errors.New("forbidden")
+		return nil, errors.New("forbidden")
+	}
+	return d, nil
+}
+
+func (s *documentService) RestoreDocument(ctx context.Context, requester, id string) (*models.Document, error) {
+	if _, err := s.trashed(ctx, requester, id); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Restore(ctx, id); err != nil {
+		return nil, err
+	}
+	d, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
 	}
-	if err := s.repo.Delete(ctx, id); err != nil {
+	// Everyone who lost the document from their lists on Trash gets it back.
+	s.invalidate(ctx, s.audience(ctx, d)...)
+	return d, nil
+}
+
+func (s *documentService) PurgeDocument(ctx context.Context, requester, id string) error {
+	if _, err := s.trashed(ctx, requester, id); err != nil {
 		return err
 	}
-	pattern := fmt.Sprintf("docs:%s:*", d.Owner)
-	keys, _ := s.cache.Keys(ctx, pattern).Result()
-	if len(keys) > 0 {
-		_, _ = s.cache.Del(ctx, keys...).Result()
+	return s.repo.Purge(ctx, id, s.releaseBlob)
+}
+
+func (s *documentService) EmptyTrash(ctx context.Context, requester string) (int, error) {
+	docs, err := s.repo.ListTrash(ctx, requester)
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	return s.purgeAll(ctx, docs)
+}
+
+func (s *documentService) PurgeTrash(ctx context.Context, t time.Time) (int, error) {
+	docs, err := s.repo.ListTrashedBefore(ctx, t)
+	if err != nil {
+		return 0, err
+	}
+	return s.purgeAll(ctx, docs)
+}
+
+// purgeAll purges docs, skipping those restored meanwhile.
+func (s *documentService) purgeAll(ctx context.Context, docs []models.Document) (int, error) {
+	n := 0
+	for _, d := range docs {
+		err := s.repo.Purge(ctx, d.ID, s.releaseBlob)
+		if errors.Is(err, repository.ErrNotInTrash) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (s *documentService) ListGrants(ctx context.Context, requester, id string) ([]models.Grant, error) {
+	d, err := s.authorize(ctx, requester, id, models.AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	return d.Grants, nil
+}
+
+func (s *documentService) AddGrant(ctx context.Context, requester, id string, g models.Grant, ifMatch []int64) ([]models.Grant, error) {
+	if g.Role == "" {
+		g.Role = models.RoleViewer
+	}
+	if err := validateGrants([]models.Grant{g}); err != nil {
+		return nil, err
+	}
+	d, err := s.authorize(ctx, requester, id, models.AccessManage)
+	if err != nil {
+		return nil, err
+	}
+	if g.Login == d.Owner {
+		return nil, ErrInvalidGrant
+	}
+	member, err := s.memberGroups(ctx, requester, []models.Grant{g})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGroupGrants([]models.Grant{g}, d.Grants, member); err != nil {
+		return nil, err
+	}
+	ifVersion, err := precondition(d, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	before := s.audience(ctx, d)
+	if err := s.repo.AddGrant(ctx, id, g, ifVersion); err != nil {
+		return nil, err
+	}
+	return s.grantsChanged(ctx, d, before)
+}
+
+func (s *documentService) RevokeGrant(ctx context.Context, requester, id string, g models.Grant, ifMatch []int64) ([]models.Grant, error) {
+	d, err := s.authorize(ctx, requester, id, models.AccessManage)
+	if err != nil {
+		return nil, err
+	}
+	ifVersion, err := precondition(d, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	before := s.audience(ctx, d)
+	if err := s.repo.RevokeGrant(ctx, id, g, ifVersion); err != nil {
+		return nil, err
+	}
+	return s.grantsChanged(ctx, d, before)
+}
+
+// grantsChanged drops the cached lists of everyone who had (before) or now
+// has access to d and returns the current grants.
+func (s *documentService) grantsChanged(ctx context.Context, d *models.Document, before []string) ([]models.Grant, error) {
+	s.invalidate(ctx, append(before, s.audience(ctx, d)...)...)
+	cur, err := s.repo.GetByID(ctx, d.ID)
+	if err != nil {
+		return nil, err
+	}
+	return cur.Grants, nil
 }
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"web-server/internal/models"
+	"web-server/internal/repository"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCheckGroupGrants(t *testing.T) {
+	member := map[string]bool{"team": true}
+	current := []models.Grant{{Group: "ops", Role: models.RoleViewer}}
+	tests := []struct {
+		name   string
+		grants []models.Grant
+		want   error
+	}{
+		{"logins only", []models.Grant{{Login: "bob", Role: models.RoleViewer}}, nil},
+		{"member group", []models.Grant{{Group: "team", Role: models.RoleViewer}}, nil},
+		{"kept foreign group", []models.Grant{{Group: "ops", Role: models.RoleViewer}}, nil},
+		{"foreign group role change", []models.Grant{{Group: "ops", Role: models.RoleEditor}}, nil},
+		{"new foreign group", []models.Grant{{Group: "ops"}, {Group: "finance", Role: models.RoleViewer}}, ErrNotGroupMember},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkGroupGrants(tt.grants, current, member); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// fakeDocs serves one document, with the access of each login given by
+// access, and records the writes made to it.
+type fakeDocs struct {
+	repository.DocumentRepository
+	doc    models.Document
+	access map[string]models.Access
+	writes []string
+	viewer string
+	owner  string
+}
+
+func (f *fakeDocs) GetWithAccess(ctx context.Context, id, login string) (*models.Document, models.Access, error) {
+	d := f.doc
+	d.Grants = slices.Clone(f.doc.Grants)
+	return &d, f.access[login], nil
+}
+
+func (f *fakeDocs) GetByID(ctx context.Context, id string) (*models.Document, error) {
+	d, _, err := f.GetWithAccess(ctx, id, "")
+	return d, err
+}
+
+func (f *fakeDocs) Audience(ctx context.Context, id string) ([]string, error) {
+	return []string{f.doc.Owner}, nil
+}
+
+func (f *fakeDocs) List(ctx context.Context, viewer, owner, key, value string, limit int) ([]models.Document, error) {
+	f.viewer, f.owner = viewer, owner
+	return []models.Document{f.doc}, nil
+}
+
+func (f *fakeDocs) Update(ctx context.Context, id string, ifVersion int64, apply func(d *models.Document) error, commitBlob repository.BlobCommitFunc, releaseBlob repository.BlobReleaseFunc) (*models.Document, error) {
+	d, _ := f.GetByID(ctx, id)
+	if err := apply(d); err != nil {
+		return nil, err
+	}
+	f.writes = append(f.writes, "update")
+	return d, nil
+}
+
+func (f *fakeDocs) Trash(ctx context.Context, id string, ifVersion int64) error {
+	f.writes = append(f.writes, "trash")
+	return nil
+}
+
+func (f *fakeDocs) AddGrant(ctx context.Context, id string, g models.Grant, ifVersion int64) error {
+	f.writes = append(f.writes, fmt.Sprintf("add grant@%d", ifVersion))
+	return nil
+}
+
+func (f *fakeDocs) RevokeGrant(ctx context.Context, id string, g models.Grant, ifVersion int64) error {
+	f.writes = append(f.writes, fmt.Sprintf("revoke grant@%d", ifVersion))
+	return nil
+}
+
+// offlineCache is a Redis client that cannot connect, so every lookup
+// misses and every write is dropped, as the service allows.
+func offlineCache() *redis.Client {
+	return redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+}
+
+func TestDocumentAccess(t *testing.T) {
+	name, public := "renamed", true
+	ops := []struct {
+		name string
+		need models.Access
+		call func(s DocumentService, requester string) error
+	}{
+		{"get", models.AccessRead, func(s DocumentService, requester string) error {
+			_, _, _, _, err := s.GetDocument(context.Background(), requester, "doc")
+			return err
+		}},
+		{"rename", models.AccessWrite, func(s DocumentService, requester string) error {
+			_, err := s.UpdateDocument(context.Background(), requester, "doc", models.DocumentPatch{Name: &name}, nil)
+			return err
+		}},
+		{"publish", models.AccessManage, func(s DocumentService, requester string) error {
+			_, err := s.UpdateDocument(context.Background(), requester, "doc", models.DocumentPatch{Public: &public}, nil)
+			return err
+		}},
+		{"add grant", models.AccessManage, func(s DocumentService, requester string) error {
+			_, err := s.AddGrant(context.Background(), requester, "doc", models.Grant{Login: "carol"}, nil)
+			return err
+		}},
+		{"delete", models.AccessOwner, func(s DocumentService, requester string) error {
+			return s.DeleteDocument(context.Background(), requester, "doc", nil)
+		}},
+	}
+	access := map[string]models.Access{
+		"":      models.AccessNone,
+		"eve":   models.AccessNone,
+		"vic":   models.AccessRead,
+		"ed":    models.AccessWrite,
+		"mia":   models.AccessManage,
+		"alice": models.AccessOwner,
+	}
+	for _, op := range ops {
+		for login, has := range access {
+			t.Run(op.name+"/"+login, func(t *testing.T) {
+				repo := &fakeDocs{doc: models.Document{ID: "doc", Owner: "alice", Name: "doc"}, access: access}
+				s := NewDocumentService(repo, nil, offlineCache(), time.Minute, nil)
+				err := op.call(s, login)
+				if allowed := has >= op.need; allowed != (err == nil) {
+					t.Errorf("err = %v with access %d, need %d", err, has, op.need)
+				}
+				if err != nil && len(repo.writes) > 0 {
+					t.Errorf("denied call wrote %v", repo.writes)
+				}
+			})
+		}
+	}
+}
+
+func TestListDocumentsViewer(t *testing.T) {
+	tests := []struct {
+		name, requester, login string
+		wantViewer, wantOwner  string
+		wantGrants             bool
+	}{
+		{"own list", "bob", "", "bob", "", true},
+		{"filtered by owner", "bob", "alice", "bob", "alice", true},
+		{"anonymous ignores login", "", "alice", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeDocs{doc: models.Document{ID: "doc", Owner: "alice", Public: true,
+				Grants: []models.Grant{{Login: "bob", Role: models.RoleViewer}}}}
+			s := NewDocumentService(repo, nil, offlineCache(), time.Minute, nil)
+			docs, err := s.ListDocuments(context.Background(), tt.requester, tt.login, "", "", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if repo.viewer != tt.wantViewer || repo.owner != tt.wantOwner {
+				t.Errorf("listed as %q, owner %q; want %q, %q", repo.viewer, repo.owner, tt.wantViewer, tt.wantOwner)
+			}
+			if got := len(docs[0].Grants) > 0; got != tt.wantGrants {
+				t.Errorf("grants shown = %v, want %v", got, tt.wantGrants)
+			}
+		})
+	}
+}
+
+// Grants are added and revoked one row at a time, conditional on the
+// version the caller saw, never by rewriting the document.
+func TestGrantChangesAreSingleRow(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch []int64
+		call    func(s DocumentService, ifMatch []int64) error
+		want    string
+	}{
+		{"add", nil, func(s DocumentService, ifMatch []int64) error {
+			_, err := s.AddGrant(context.Background(), "alice", "doc", models.Grant{Login: "bob"}, ifMatch)
+			return err
+		}, "add grant@0"},
+		{"add if match", []int64{3}, func(s DocumentService, ifMatch []int64) error {
+			_, err := s.AddGrant(context.Background(), "alice", "doc", models.Grant{Login: "bob"}, ifMatch)
+			return err
+		}, "add grant@3"},
+		{"revoke if match", []int64{3}, func(s DocumentService, ifMatch []int64) error {
+			_, err := s.RevokeGrant(context.Background(), "alice", "doc", models.Grant{Login: "bob"}, ifMatch)
+			return err
+		}, "revoke grant@3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeDocs{doc: models.Document{ID: "doc", Owner: "alice", Version: 3},
+				access: map[string]models.Access{"alice": models.AccessOwner}}
+			s := NewDocumentService(repo, nil, offlineCache(), time.Minute, nil)
+			if err := tt.call(s, tt.ifMatch); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(repo.writes, []string{tt.want}) {
+				t.Errorf("writes %v, want [%s]", repo.writes, tt.want)
+			}
+		})
+	}
+}
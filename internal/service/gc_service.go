@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"web-server/internal/models"
+	"web-server/internal/repository"
+	"web-server/internal/storage"
+)
+
+// gcGrace keeps blobs written recently out of the sweep: an upload stages
+// and publishes its blob before the document row becomes visible.
+const gcGrace = time.Hour
+
+type GCReport struct {
+	Scanned int      `json:"scanned"`
+	Orphans []string `json:"orphans"`
+	Removed int      `json:"removed"`
+	Missing []string `json:"missing"`
+	// Reaped counts blobs deleted because their last reference went.
+	Reaped int `json:"reaped"`
+}
+
+// StorageGC reconciles the blob store with the documents table.
+type StorageGC interface {
+	// Sweep reports blobs no document refers to, and documents whose file
+	// is missing. If remove is set the orphaned blobs are deleted, and so
+	// are unreferenced blobs that were not reaped right after their last
+	// reference went.
+	Sweep(ctx context.Context, remove bool) (*GCReport, error)
+}
+
+type storageGC struct {
+	repo  repository.DocumentRepository
+	blobs storage.BlobStore
+}
+
+func NewStorageGC(repo repository.DocumentRepository, blobs storage.BlobStore) StorageGC {
+	return &storageGC{repo: repo, blobs: blobs}
+}
+
+func (g *storageGC) Sweep(ctx context.Context, remove bool) (*GCReport, error) {
+	reaped := 0
+	if remove {
+		n, err := g.repo.ReapBlobs(ctx, func(ctx context.Context, d *models.Document) error {
+			if err := g.blobs.Delete(ctx, fileKey(d)); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		reaped = n
+	}
+	files, err := g.repo.ListFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := g.blobs.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	present := make(map[string]bool, len(stored))
+	for _, b := range stored {
+		present[b.Key] = true
+	}
+	referenced := make(map[string]bool, len(files))
+	report := &GCReport{Scanned: len(stored), Orphans: []string{}, Missing: []string{}, Reaped: reaped}
+	for i := range files {
+		key := fileKey(&files[i])
+		referenced[key] = true
+		if !present[key] {
+			report.Missing = append(report.Missing, files[i].ID)
+		}
+	}
+
+	cutoff := time.Now().Add(-gcGrace)
+	for _, b := range stored {
+		// In-progress resumable uploads are owned by UploadService.
+		if referenced[b.Key] || strings.HasPrefix(b.Key, "tus/") || b.ModTime.After(cutoff) {
+			continue
+		}
+		report.Orphans = append(report.Orphans, b.Key)
+		if remove {
+			if err := g.blobs.Delete(ctx, b.Key); err == nil {
+				report.Removed++
+			}
+		}
+	}
+	return report, nil
+}
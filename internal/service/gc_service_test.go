@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"web-server/internal/models"
+	"web-server/internal/repository"
+	"web-server/internal/storage"
+)
+
+// blobRepo holds the referenced files and the blob rows left at refcount 0.
+type blobRepo struct {
+	repository.DocumentRepository
+	files      []models.Document
+	tombstones []models.Document
+	reaps      int
+}
+
+func (r *blobRepo) ListFiles(ctx context.Context) ([]models.Document, error) {
+	return r.files, nil
+}
+
+func (r *blobRepo) ReapBlobs(ctx context.Context, releaseBlob repository.BlobReleaseFunc) (int, error) {
+	r.reaps++
+	n := 0
+	for i := range r.tombstones {
+		if err := releaseBlob(ctx, &r.tombstones[i]); err != nil {
+			return n, err
+		}
+		n++
+	}
+	r.tombstones = nil
+	return n, nil
+}
+
+func digest(c byte) string {
+	return strings.Repeat(string(c), 64)
+}
+
+// putBlob stores key, backdated by age.
+func putBlob(t *testing.T, dir string, blobs storage.BlobStore, key string, age time.Duration) {
+	t.Helper()
+	if _, err := blobs.Put(context.Background(), key, strings.NewReader(key)); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-age)
+	if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	for _, remove := range []bool{false, true} {
+		t.Run(map[bool]string{false: "report", true: "remove"}[remove], func(t *testing.T) {
+			dir := t.TempDir()
+			blobs, err := storage.NewLocal(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			day := 24 * time.Hour
+			putBlob(t, dir, blobs, blobKey(digest('a')), day) // referenced
+			putBlob(t, dir, blobs, blobKey(digest('b')), day) // orphan
+			putBlob(t, dir, blobs, blobKey(digest('c')), day) // last reference gone
+			putBlob(t, dir, blobs, blobKey(digest('d')), 0)   // just staged
+			putBlob(t, dir, blobs, "tus/upload", day)
+			repo := &blobRepo{
+				files: []models.Document{
+					{ID: "kept", Digest: digest('a')},
+					{ID: "lost", Digest: digest('e')},
+				},
+				tombstones: []models.Document{
+					{Digest: digest('c')},
+					{Digest: digest('f')}, // file already gone
+				},
+			}
+
+			report, err := NewStorageGC(repo, blobs).Sweep(context.Background(), remove)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantOrphans := []string{blobKey(digest('b')), blobKey(digest('c'))}
+			wantStored := []string{blobKey(digest('a')), blobKey(digest('b')), blobKey(digest('c')), blobKey(digest('d')), "tus/upload"}
+			wantReaps, wantReaped := 0, 0
+			if remove {
+				wantOrphans = wantOrphans[:1]
+				wantStored = []string{blobKey(digest('a')), blobKey(digest('d')), "tus/upload"}
+				wantReaps, wantReaped = 1, 2
+			}
+			if repo.reaps != wantReaps || report.Reaped != wantReaped {
+				t.Errorf("reaped %d in %d calls, want %d in %d", report.Reaped, repo.reaps, wantReaped, wantReaps)
+			}
+			if !slices.Equal(report.Orphans, wantOrphans) {
+				t.Errorf("orphans %v, want %v", report.Orphans, wantOrphans)
+			}
+			if !slices.Equal(report.Missing, []string{"lost"}) {
+				t.Errorf("missing %v, want [lost]", report.Missing)
+			}
+			stored, err := blobs.List(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, b := range stored {
+				keys = append(keys, b.Key)
+			}
+			slices.Sort(keys)
+			if !slices.Equal(keys, wantStored) {
+				t.Errorf("stored %v, want %v", keys, wantStored)
+			}
+		})
+	}
+}
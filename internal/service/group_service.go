@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"web-server/internal/models"
+	"web-server/internal/repository"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrGroupExists      = repository.ErrGroupExists
+	ErrGroupNotFound    = repository.ErrGroupNotFound
+	ErrInvalidGroupName = errors.New("invalid group name")
+)
+
+var groupNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,64}$`)
+
+// GroupService manages groups of users, which documents can be shared with
+// like single users. Only the owner of a group changes its members; any
+// member may leave.
+type GroupService interface {
+	Create(ctx context.Context, requester, name string) (*models.Group, error)
+	List(ctx context.Context, requester string) ([]models.Group, error)
+	Get(ctx context.Context, requester, name string) (*models.Group, error)
+	AddMember(ctx context.Context, requester, name, login string) (*models.Group, error)
+	RemoveMember(ctx context.Context, requester, name, login string) (*models.Group, error)
+	Delete(ctx context.Context, requester, name string) error
+}
+
+type groupService struct {
+	repo  repository.GroupRepository
+	cache *redis.Client
+}
+
+func NewGroupService(repo repository.GroupRepository, cache *redis.Client) GroupService {
+	return &groupService{repo: repo, cache: cache}
+}
+
+func (s *groupService) Create(ctx context.Context, requester, name string) (*models.Group, error) {
+	if !groupNameRe.MatchString(name) {
+		return nil, ErrInvalidGroupName
+	}
+	return s.repo.Create(ctx, name, requester)
+}
+
+func (s *groupService) List(ctx context.Context, requester string) ([]models.Group, error) {
+	return s.repo.ListForUser(ctx, requester)
+}
+
+func (s *groupService) Get(ctx context.Context, requester, name string) (*models.Group, error) {
+	g, err := s.repo.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if g.Owner != requester && !slices.Contains(g.Members, requester) {
+		return nil, errors.New("forbidden")
+	}
+	return g, nil
+}
+
+// owned loads group name if requester owns it.
+func (s *groupService) owned(ctx context.Context, requester, name string) (*models.Group, error) {
+	g, err := s.repo.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if g.Owner != requester {
+		return nil, errors.New("forbidden")
+	}
+	return g, nil
+}
+
+func (s *groupService) AddMember(ctx context.Context, requester, name, login string) (*models.Group, error) {
+	if _, err := s.owned(ctx, requester, name); err != nil {
+		return nil, err
+	}
+	if err := s.repo.AddMember(ctx, name, login); err != nil {
+		return nil, err
+	}
+	// The new member now sees the documents shared with the group.
+	invalidateLists(ctx, s.cache, login)
+	return s.repo.Get(ctx, name)
+}
+
+func (s *groupService) RemoveMember(ctx context.Context, requester, name, login string) (*models.Group, error) {
+	if login == requester {
+		if _, err := s.repo.Get(ctx, name); err != nil {
+			return nil, err
+		}
+	} else if _, err := s.owned(ctx, requester, name); err != nil {
+		return nil, err
+	}
+	if err := s.repo.RemoveMember(ctx, name, login); err != nil {
+		return nil, err
+	}
+	invalidateLists(ctx, s.cache, login)
+	return s.repo.Get(ctx, name)
+}
+
+func (s *groupService) Delete(ctx context.Context, requester, name string) error {
+	g, err := s.owned(ctx, requester, name)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, name); err != nil {
+		return err
+	}
+	invalidateLists(ctx, s.cache, g.Members...)
+	return nil
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+	"web-server/internal/models"
+	"web-server/internal/repository"
+	"web-server/internal/storage"
+	"web-server/internal/util"
+)
+
+var (
+	ErrLinkNotFound  = errors.New("link not found")
+	ErrLinkExpired   = errors.New("link expired")
+	ErrLinkPassword  = errors.New("link password required")
+	ErrInvalidLink   = errors.New("invalid link options")
+	ErrLinkExhausted = repository.ErrLinkExhausted
+)
+
+// ShareOptions are the settings of a new share link; zero values mean the
+// default expiry, no password and no download limit.
+type ShareOptions struct {
+	ExpiresAt    *time.Time `json:"expires"`
+	Password     string     `json:"password"`
+	MaxDownloads *int       `json:"max_downloads"`
+}
+
+// ShareService manages share links: tokens that give read access to one
+// document without an account. Links are created, listed and revoked by
+// users with the manager role on the document.
+type ShareService interface {
+	// Create returns the link and its token, which is not stored and cannot
+	// be shown again.
+	Create(ctx context.Context, requester, docID string, opts ShareOptions) (*models.ShareLink, string, error)
+	List(ctx context.Context, requester, docID string) ([]models.ShareLink, error)
+	Revoke(ctx context.Context, requester, docID, linkID string) error
+	// Open resolves a token to the document. If count is set, one download
+	// is taken from the link's limit once the file has been opened.
+	Open(ctx context.Context, token, password string, count bool) (*models.Document, io.ReadSeekCloser, map[string]any, error)
+}
+
+type shareService struct {
+	repo  repository.ShareRepository
+	docs  repository.DocumentRepository
+	blobs storage.BlobStore
+	ttl   time.Duration
+}
+
+func NewShareService(repo repository.ShareRepository, docs repository.DocumentRepository, blobs storage.BlobStore, ttl time.Duration) ShareService {
+	return &shareService{repo: repo, docs: docs, blobs: blobs, ttl: ttl}
+}
+
+func (s *shareService) Create(ctx context.Context, requester, docID string, opts ShareOptions) (*models.ShareLink, string, error) {
+	if _, err := authorizeDocument(ctx, s.docs, requester, docID, models.AccessManage); err != nil {
+		return nil, "", err
+	}
+	l := &models.ShareLink{
+		DocumentID:   docID,
+		CreatedBy:    requester,
+		ExpiresAt:    time.Now().Add(s.ttl),
+		MaxDownloads: opts.MaxDownloads,
+	}
+	if opts.ExpiresAt != nil {
+		if !opts.ExpiresAt.After(time.Now()) {
+			return nil, "", ErrInvalidLink
+		}
+		l.ExpiresAt = *opts.ExpiresAt
+	}
+	if l.MaxDownloads != nil && *l.MaxDownloads <= 0 {
+		return nil, "", ErrInvalidLink
+	}
+	if opts.Password != "" {
+		hash, err := util.HashPassword(opts.Password)
+		if err != nil {
+			return nil, "", err
+		}
+		l.PasswordHash, l.HasPassword = hash, true
+	}
+
+	token, err := util.NewToken()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.repo.Create(ctx, l, util.HashToken(token)); err != nil {
+		return nil, "", err
+	}
+	return l, token, nil
+}
+
+func (s *shareService) List(ctx context.Context, requester, docID string) ([]models.ShareLink, error) {
+	if _, err := authorizeDocument(ctx, s.docs, requester, docID, models.AccessManage); err != nil {
+		return nil, err
+	}
+	return s.repo.ListByDocument(ctx, docID)
+}
+
+func (s *shareService) Revoke(ctx context.Context, requester, docID, linkID string) error {
+	if _, err := authorizeDocument(ctx, s.docs, requester, docID, models.AccessManage); err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, docID, linkID); err != nil {
+		return ErrLinkNotFound
+	}
+	return nil
+}
+
+func (s *shareService) Open(ctx context.Context, token, password string, count bool) (*models.Document, io.ReadSeekCloser, map[string]any, error) {
+	l, err := s.repo.GetByToken(ctx, util.HashToken(token))
+	if err != nil {
+		return nil, nil, nil, ErrLinkNotFound
+	}
+	if !time.Now().Before(l.ExpiresAt) {
+		return nil, nil, nil, ErrLinkExpired
+	}
+	if l.PasswordHash != "" && util.CheckPassword(l.PasswordHash, password) != nil {
+		return nil, nil, nil, ErrLinkPassword
+	}
+	if l.MaxDownloads != nil && l.Downloads >= *l.MaxDownloads {
+		return nil, nil, nil, ErrLinkExhausted
+	}
+	// Trashed documents are not served.
+	d, err := s.docs.GetByID(ctx, l.DocumentID)
+	if err != nil {
+		return nil, nil, nil, ErrLinkNotFound
+	}
+	file, err := openFile(ctx, s.blobs, d)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// A storage failure must not use up a download.
+	if count {
+		if err := s.repo.Consume(ctx, l.ID); err != nil {
+			if file != nil {
+				file.Close()
+			}
+			return nil, nil, nil, err
+		}
+	}
+	var jsonData map[string]any
+	if len(d.JSONRaw) > 0 {
+		_ = json.Unmarshal(d.JSONRaw, &jsonData)
+	}
+	return d, file, jsonData, nil
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"web-server/internal/models"
+	"web-server/internal/repository"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AdminActor stands for the holder of the admin token. It is shorter than
+// any valid login, so it cannot be mistaken for a user.
+const AdminActor = "admin"
+
+// ErrInvalidTransfer means the target login is missing or already owns the
+// document, or the role kept by the previous owner is unknown.
+var ErrInvalidTransfer = errors.New("invalid transfer")
+
+// TransferService hands documents over to another owner. The owner or
+// AdminActor may transfer a document; every transfer is recorded.
+type TransferService interface {
+	// TransferDocument makes to the owner of document id. keep, if not
+	// empty, is the role the previous owner retains on it.
+	TransferDocument(ctx context.Context, actor, id, to, keep string, ifMatch []int64) (*models.Transfer, error)
+	// TransferAll transfers every document of from, trashed ones included.
+	TransferAll(ctx context.Context, actor, from, to, keep string) ([]models.Transfer, error)
+	// ListTransfers returns the transfers actor took part in; AdminActor
+	// gets those of login, or all of them if login is empty.
+	ListTransfers(ctx context.Context, actor, login string) ([]models.Transfer, error)
+}
+
+type transferService struct {
+	repo  repository.TransferRepository
+	docs  repository.DocumentRepository
+	cache *redis.Client
+}
+
+func NewTransferService(repo repository.TransferRepository, docs repository.DocumentRepository, cache *redis.Client) TransferService {
+	return &transferService{repo: repo, docs: docs, cache: cache}
+}
+
+func validateTransfer(from, to, keep string) error {
+	if to == "" || to == from || (keep != "" && models.RoleAccess(keep) == models.AccessNone) {
+		return ErrInvalidTransfer
+	}
+	return nil
+}
+
+func (s *transferService) TransferDocument(ctx context.Context, actor, id, to, keep string, ifMatch []int64) (*models.Transfer, error) {
+	var d *models.Document
+	var err error
+	if actor == AdminActor {
+		d, err = s.docs.GetByID(ctx, id)
+	} else {
+		d, err = authorizeDocument(ctx, s.docs, actor, id, models.AccessOwner)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTransfer(d.Owner, to, keep); err != nil {
+		return nil, err
+	}
+	ifVersion, err := precondition(d, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.repo.Transfer(ctx, id, to, actor, keep, ifVersion)
+	if err != nil {
+		return nil, err
+	}
+	s.invalidate(ctx, []models.Transfer{*t})
+	return t, nil
+}
+
+func (s *transferService) TransferAll(ctx context.Context, actor, from, to, keep string) ([]models.Transfer, error) {
+	if actor != AdminActor && actor != from {
+		return nil, errors.New("forbidden")
+	}
+	if err := validateTransfer(from, to, keep); err != nil {
+		return nil, err
+	}
+	ts, err := s.repo.TransferAll(ctx, from, to, actor, keep)
+	if err != nil {
+		return nil, err
+	}
+	s.invalidate(ctx, ts)
+	return ts, nil
+}
+
+func (s *transferService) ListTransfers(ctx context.Context, actor, login string) ([]models.Transfer, error) {
+	if actor != AdminActor {
+		login = actor
+	}
+	return s.repo.List(ctx, login)
+}
+
+// invalidate drops the cached lists of both owners and of everyone else
+// who sees the transferred documents, since each listing shows the owner.
+func (s *transferService) invalidate(ctx context.Context, ts []models.Transfer) {
+	seen := map[string]bool{"": true}
+	for _, t := range ts {
+		seen[t.From], seen[t.To] = true, true
+		logins, _ := s.docs.Audience(ctx, t.DocumentID)
+		for _, l := range logins {
+			seen[l] = true
+		}
+	}
+	logins := make([]string, 0, len(seen))
+	for l := range seen {
+		logins = append(logins, l)
+	}
+	invalidateLists(ctx, s.cache, logins...)
+}
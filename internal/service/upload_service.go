@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"web-server/internal/models"
+	"web-server/internal/repository"
+	"web-server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUploadNotFound   = errors.New("upload not found")
+	ErrUploadExpired    = errors.New("upload expired")
+	ErrOffsetMismatch   = errors.New("offset mismatch")
+	ErrUploadTooLarge   = errors.New("chunk exceeds upload length")
+	ErrUploadCompleting = errors.New("upload is being completed")
+)
+
+// UploadService implements resumable uploads (tus 1.0). Chunks are kept in
+// the blob store as separate parts and handed to DocumentService as one
+// stream once the upload is complete.
+type UploadService interface {
+	Create(ctx context.Context, owner string, length int64, meta models.DocumentMeta, jsonRaw []byte) (*models.Upload, string, error)
+	Get(ctx context.Context, owner, id string) (*models.Upload, error)
+	Append(ctx context.Context, owner, id string, offset int64, r io.Reader) (*models.Upload, string, error)
+	Terminate(ctx context.Context, owner, id string) error
+	PurgeExpired(ctx context.Context) (int, error)
+}
+
+type uploadService struct {
+	repo   repository.UploadRepository
+	blobs  storage.BlobStore
+	docs   DocumentService
+	expiry time.Duration
+}
+
+func NewUploadService(repo repository.UploadRepository, blobs storage.BlobStore, docs DocumentService, expiry time.Duration) UploadService {
+	return &uploadService{repo: repo, blobs: blobs, docs: docs, expiry: expiry}
+}
+
+func (s *uploadService) Create(ctx context.Context, owner string, length int64, meta models.DocumentMeta, jsonRaw []byte) (*models.Upload, string, error) {
+	if length < 0 {
+		return nil, "", errors.New("invalid upload length")
+	}
+	meta.File = true
+	now := time.Now()
+	u := &models.Upload{
+		ID:        uuid.NewString(),
+		Owner:     owner,
+		Length:    length,
+		Meta:      meta,
+		JSONRaw:   jsonRaw,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.expiry),
+	}
+	if err := s.repo.Create(ctx, u); err != nil {
+		return nil, "", err
+	}
+	if length == 0 {
+		docID, err := s.finish(ctx, u)
+		return u, docID, err
+	}
+	return u, "", nil
+}
+
+func (s *uploadService) Get(ctx context.Context, owner, id string) (*models.Upload, error) {
+	u, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+	if u.Owner != owner {
+		return nil, errors.New("forbidden")
+	}
+	if time.Now().After(u.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+	return u, nil
+}
+
+// Append stores r as the chunk starting at offset. When the chunk completes
+// the upload, the document is created and its id returned. If the body breaks
+// off, the bytes received so far are kept and the offset advanced past them,
+// so the client resumes from there. A chunk running past the upload length
+// is refused as a whole with ErrUploadTooLarge.
+func (s *uploadService) Append(ctx context.Context, owner, id string, offset int64, r io.Reader) (*models.Upload, string, error) {
+	u, err := s.Get(ctx, owner, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if offset != u.Offset {
+		return u, "", ErrOffsetMismatch
+	}
+	var readErr error
+	if u.Offset < u.Length {
+		// A dropped connection cancels ctx; the received bytes are still
+		// stored and recorded.
+		wctx := context.WithoutCancel(ctx)
+		body := &cutReader{r: io.LimitReader(r, u.Length-u.Offset)}
+		part := fmt.Sprintf("tus/%s/%020d-%s", u.ID, offset, uuid.NewString()[:8])
+		n, err := s.blobs.Put(wctx, part, body)
+		if err != nil {
+			_ = s.blobs.Delete(wctx, part)
+			return u, "", err
+		}
+		readErr = body.err
+		if readErr == nil && n == u.Length-u.Offset {
+			if k, _ := io.ReadFull(r, make([]byte, 1)); k > 0 {
+				_ = s.blobs.Delete(wctx, part)
+				return u, "", ErrUploadTooLarge
+			}
+		}
+		if n == 0 {
+			_ = s.blobs.Delete(wctx, part)
+			return u, "", readErr
+		}
+		if err := s.repo.Advance(wctx, u.ID, offset, offset+n, part); err != nil {
+			_ = s.blobs.Delete(wctx, part)
+			if errors.Is(err, repository.ErrOffsetConflict) {
+				return u, "", ErrOffsetMismatch
+			}
+			return u, "", err
+		}
+		u.Offset += n
+		u.Parts = append(u.Parts, part)
+	}
+	if readErr != nil || u.Offset < u.Length {
+		return u, "", readErr
+	}
+	docID, err := s.finish(ctx, u)
+	return u, docID, err
+}
+
+// cutReader ends the stream at the first read error instead of failing it,
+// so the bytes before the error can be stored. The error is kept in err.
+type cutReader struct {
+	r   io.Reader
+	err error
+}
+
+func (c *cutReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil && err != io.EOF {
+		c.err = err
+		err = io.EOF
+	}
+	return n, err
+}
+
+// finish concatenates the parts into a document. Only the request that
+// claims the upload does so; a concurrent one gets ErrOffsetMismatch. On
+// failure the claim is dropped and the upload kept, so an empty PATCH at the
+// final offset retries it.
+func (s *uploadService) finish(ctx context.Context, u *models.Upload) (string, error) {
+	if err := s.repo.Claim(ctx, u.ID); err != nil {
+		if errors.Is(err, repository.ErrUploadClaimed) {
+			return "", ErrOffsetMismatch
+		}
+		return "", err
+	}
+	var jsonData map[string]any
+	if len(u.JSONRaw) > 0 {
+		_ = json.Unmarshal(u.JSONRaw, &jsonData)
+	}
+	r := &partsReader{ctx: ctx, blobs: s.blobs, parts: u.Parts}
+	defer r.Close()
+	docID, err := s.docs.CreateDocument(ctx, u.Owner, u.Meta, jsonData, r)
+	if err != nil {
+		_ = s.repo.Unclaim(context.WithoutCancel(ctx), u.ID)
+		return "", err
+	}
+	if err := s.repo.Complete(ctx, u.ID); err == nil {
+		s.deleteParts(ctx, u)
+	}
+	return docID, nil
+}
+
+// remove deletes an upload and its parts, unless a request is completing
+// it and still reading the parts.
+func (s *uploadService) remove(ctx context.Context, u *models.Upload) error {
+	if err := s.repo.Delete(ctx, u.ID); err != nil {
+		if errors.Is(err, repository.ErrUploadClaimed) {
+			return ErrUploadCompleting
+		}
+		if err.Error() == "not found" {
+			return ErrUploadNotFound
+		}
+		return err
+	}
+	s.deleteParts(ctx, u)
+	return nil
+}
+
+func (s *uploadService) deleteParts(ctx context.Context, u *models.Upload) {
+	for _, p := range u.Parts {
+		_ = s.blobs.Delete(ctx, p)
+	}
+}
+
+func (s *uploadService) Terminate(ctx context.Context, owner, id string) error {
+	u, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return ErrUploadNotFound
+	}
+	if u.Owner != owner {
+		return errors.New("forbidden")
+	}
+	return s.remove(ctx, u)
+}
+
+func (s *uploadService) PurgeExpired(ctx context.Context) (int, error) {
+	expired, err := s.repo.ListExpired(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := range expired {
+		// Claimed or deleted since listing: leave it.
+		if s.remove(ctx, &expired[i]) == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// partsReader reads the stored chunks one after another, opening each only
+// when the previous one is exhausted.
+type partsReader struct {
+	ctx   context.Context
+	blobs storage.BlobStore
+	parts []string
+	cur   io.ReadCloser
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := r.blobs.Get(r.ctx, r.parts[0])
+			if err != nil {
+				return 0, err
+			}
+			r.cur, r.parts = rc, r.parts[1:]
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *partsReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"web-server/internal/models"
+	"web-server/internal/repository"
+	"web-server/internal/storage"
+)
+
+// memUploads keeps uploads in memory with the claim rules of the table.
+type memUploads struct {
+	uploads    map[string]*models.Upload
+	completing map[string]bool
+}
+
+func newMemUploads() *memUploads {
+	return &memUploads{uploads: map[string]*models.Upload{}, completing: map[string]bool{}}
+}
+
+func (m *memUploads) Create(ctx context.Context, u *models.Upload) error {
+	c := *u
+	m.uploads[u.ID] = &c
+	return nil
+}
+
+func (m *memUploads) Get(ctx context.Context, id string) (*models.Upload, error) {
+	u, ok := m.uploads[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	c := *u
+	c.Parts = slices.Clone(u.Parts)
+	return &c, nil
+}
+
+func (m *memUploads) Advance(ctx context.Context, id string, from, to int64, part string) error {
+	u, ok := m.uploads[id]
+	if !ok || u.Offset != from {
+		return repository.ErrOffsetConflict
+	}
+	u.Offset = to
+	u.Parts = append(u.Parts, part)
+	return nil
+}
+
+func (m *memUploads) Claim(ctx context.Context, id string) error {
+	if m.completing[id] {
+		return repository.ErrUploadClaimed
+	}
+	m.completing[id] = true
+	return nil
+}
+
+func (m *memUploads) Unclaim(ctx context.Context, id string) error {
+	delete(m.completing, id)
+	return nil
+}
+
+func (m *memUploads) Delete(ctx context.Context, id string) error {
+	if _, ok := m.uploads[id]; !ok {
+		return errors.New("not found")
+	}
+	if m.completing[id] {
+		return repository.ErrUploadClaimed
+	}
+	delete(m.uploads, id)
+	return nil
+}
+
+func (m *memUploads) Complete(ctx context.Context, id string) error {
+	if m.completing[id] {
+		delete(m.uploads, id)
+		delete(m.completing, id)
+	}
+	return nil
+}
+
+func (m *memUploads) ListExpired(ctx context.Context, now time.Time) ([]models.Upload, error) {
+	var out []models.Upload
+	for id, u := range m.uploads {
+		if u.ExpiresAt.Before(now) && !m.completing[id] {
+			out = append(out, *u)
+		}
+	}
+	return out, nil
+}
+
+// uploadDocs records the file of each created document. during runs while
+// the file is being read, as a concurrent request would.
+type uploadDocs struct {
+	DocumentService
+	files  []string
+	during func()
+	fail   error
+}
+
+func (d *uploadDocs) CreateDocument(ctx context.Context, owner string, meta models.DocumentMeta, jsonData map[string]any, file io.Reader) (string, error) {
+	if d.during != nil {
+		d.during()
+	}
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+	if d.fail != nil {
+		return "", d.fail
+	}
+	d.files = append(d.files, string(b))
+	return "doc", nil
+}
+
+func newTestUploads(t *testing.T) (*uploadService, *memUploads, *uploadDocs, storage.BlobStore) {
+	t.Helper()
+	blobs, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, docs := newMemUploads(), &uploadDocs{}
+	return NewUploadService(repo, blobs, docs, time.Hour).(*uploadService), repo, docs, blobs
+}
+
+func parts(t *testing.T, blobs storage.BlobStore) int {
+	t.Helper()
+	stored, err := blobs.List(context.Background(), "tus/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(stored)
+}
+
+func TestUploadComplete(t *testing.T) {
+	ctx := context.Background()
+	s, repo, docs, blobs := newTestUploads(t)
+	u, _, err := s.Create(ctx, "alice", 6, models.DocumentMeta{Name: "a.txt"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, id, err := s.Append(ctx, "alice", u.ID, 0, strings.NewReader("abc")); err != nil || id != "" {
+		t.Fatalf("first chunk: %q, %v", id, err)
+	}
+	if _, id, err := s.Append(ctx, "alice", u.ID, 3, strings.NewReader("def")); err != nil || id != "doc" {
+		t.Fatalf("last chunk: %q, %v", id, err)
+	}
+	if !slices.Equal(docs.files, []string{"abcdef"}) {
+		t.Errorf("documents %q", docs.files)
+	}
+	if len(repo.uploads) != 0 || parts(t, blobs) != 0 {
+		t.Errorf("%d uploads and %d parts left", len(repo.uploads), parts(t, blobs))
+	}
+}
+
+func TestUploadTooLarge(t *testing.T) {
+	ctx := context.Background()
+	s, repo, docs, blobs := newTestUploads(t)
+	u, _, _ := s.Create(ctx, "alice", 3, models.DocumentMeta{Name: "a.txt"}, nil)
+	if _, _, err := s.Append(ctx, "alice", u.ID, 0, strings.NewReader("abcd")); !errors.Is(err, ErrUploadTooLarge) {
+		t.Fatalf("got %v, want ErrUploadTooLarge", err)
+	}
+	if repo.uploads[u.ID].Offset != 0 || parts(t, blobs) != 0 || len(docs.files) != 0 {
+		t.Errorf("oversized chunk was kept")
+	}
+	if _, id, err := s.Append(ctx, "alice", u.ID, 0, strings.NewReader("abc")); err != nil || id != "doc" {
+		t.Errorf("exact chunk: %q, %v", id, err)
+	}
+}
+
+// While one request assembles the document, the upload can be neither
+// terminated, purged nor completed a second time.
+func TestUploadClaimedWhileCompleting(t *testing.T) {
+	ctx := context.Background()
+	s, repo, docs, blobs := newTestUploads(t)
+	u, _, _ := s.Create(ctx, "alice", 3, models.DocumentMeta{Name: "a.txt"}, nil)
+	docs.during = func() {
+		docs.during = nil
+		if err := s.Terminate(ctx, "alice", u.ID); !errors.Is(err, ErrUploadCompleting) {
+			t.Errorf("terminate: %v, want ErrUploadCompleting", err)
+		}
+		if _, _, err := s.Append(ctx, "alice", u.ID, 3, strings.NewReader("")); !errors.Is(err, ErrOffsetMismatch) {
+			t.Errorf("second completion: %v, want ErrOffsetMismatch", err)
+		}
+		repo.uploads[u.ID].ExpiresAt = time.Now().Add(-time.Minute)
+		if n, err := s.PurgeExpired(ctx); n != 0 || err != nil {
+			t.Errorf("purged %d, %v", n, err)
+		}
+	}
+	if _, id, err := s.Append(ctx, "alice", u.ID, 0, strings.NewReader("abc")); err != nil || id != "doc" {
+		t.Fatalf("complete: %q, %v", id, err)
+	}
+	if !slices.Equal(docs.files, []string{"abc"}) {
+		t.Errorf("documents %q", docs.files)
+	}
+	if len(repo.uploads) != 0 || parts(t, blobs) != 0 {
+		t.Errorf("%d uploads and %d parts left", len(repo.uploads), parts(t, blobs))
+	}
+}
+
+// A failed completion hands the claim back and keeps the parts, so an empty
+// chunk at the final offset retries it.
+func TestUploadCompleteRetry(t *testing.T) {
+	ctx := context.Background()
+	s, repo, docs, blobs := newTestUploads(t)
+	u, _, _ := s.Create(ctx, "alice", 3, models.DocumentMeta{Name: "a.txt"}, nil)
+	docs.fail = errors.New("insert failed")
+	if _, _, err := s.Append(ctx, "alice", u.ID, 0, strings.NewReader("abc")); !errors.Is(err, docs.fail) {
+		t.Fatalf("got %v, want the insert error", err)
+	}
+	if repo.completing[u.ID] || parts(t, blobs) != 1 {
+		t.Fatalf("claim kept or parts lost")
+	}
+	docs.fail = nil
+	if _, id, err := s.Append(ctx, "alice", u.ID, 3, strings.NewReader("")); err != nil || id != "doc" {
+		t.Fatalf("retry: %q, %v", id, err)
+	}
+	if !slices.Equal(docs.files, []string{"abc"}) {
+		t.Errorf("documents %q", docs.files)
+	}
+}
+
+func TestPurgeExpiredUploads(t *testing.T) {
+	ctx := context.Background()
+	s, repo, _, _ := newTestUploads(t)
+	past := time.Now().Add(-time.Minute)
+	for _, id := range []string{"idle", "claimed", "live"} {
+		exp := past
+		if id == "live" {
+			exp = time.Now().Add(time.Hour)
+		}
+		repo.uploads[id] = &models.Upload{ID: id, Owner: "alice", Length: 3, ExpiresAt: exp}
+	}
+	repo.completing["claimed"] = true
+	if n, err := s.PurgeExpired(ctx); n != 1 || err != nil {
+		t.Fatalf("purged %d, %v; want 1", n, err)
+	}
+	if _, ok := repo.uploads["idle"]; ok {
+		t.Error("expired upload kept")
+	}
+	if _, ok := repo.uploads["claimed"]; !ok {
+		t.Error("claimed upload purged")
+	}
+}
@@ -4,26 +4,117 @@ import (
 	"context"
 	"errors"
 	"regexp"
+	"sync"
 	"time"
+	"web-server/internal/models"
 	"web-server/internal/repository"
 	"web-server/internal/util"
 
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 )
 
 type UserService interface {
 	Register(ctx context.Context, adminToken, login, password, configToken string) error
-	Auth(ctx context.Context, login, password string, ttl time.Duration) (string, error)
+	// Auth signs in and opens a new session; client carries the user agent
+	// and IP it is recorded with.
+	Auth(ctx context.Context, login, password string, client models.Session) (*Tokens, error)
+	// Refresh trades a refresh token for a new token pair. The old refresh
+	// token stops working; using it again revokes the session.
+	Refresh(ctx context.Context, refreshToken string) (*Tokens, error)
+	// ValidateToken accepts session tokens only.
 	ValidateToken(ctx context.Context, token string) (string, error)
+	// Authorize accepts session tokens and API keys. An API key must carry
+	// scope and be used from one of its allowed IPs.
+	Authorize(ctx context.Context, token, ip, scope string) (string, error)
 	Logout(ctx context.Context, token string) error
+	// ListSessions returns the live sessions of login, marking the one of
+	// current.
+	ListSessions(ctx context.Context, login, current string) ([]models.Session, error)
+	RevokeSession(ctx context.Context, login, id string) error
+	// RevokeOtherSessions signs login out everywhere but the session of
+	// current and returns how many sessions ended.
+	RevokeOtherSessions(ctx context.Context, login, current string) (int, error)
+	// SyncRevocations reloads the list of revoked signed tokens, picking up
+	// logouts on other instances. It does nothing for session tokens.
+	SyncRevocations(ctx context.Context) error
+
+	// CreateAPIKey returns the key and its secret, which is not stored and
+	// cannot be shown again.
+	CreateAPIKey(ctx context.Context, login string, opts APIKeyOptions) (*models.APIKey, string, error)
+	ListAPIKeys(ctx context.Context, login string) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, login, id string) error
+}
+
+// ErrInvalidRefreshToken means the refresh token is unknown or its session
+// has ended. ErrRefreshTokenReused means it had already been used, and its
+// session was revoked.
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = repository.ErrRefreshReused
+)
+
+// Tokens is what a sign-in or refresh returns. RefreshToken is empty when
+// refresh tokens are disabled.
+type Tokens struct {
+	Token        string    `json:"token"`
+	ExpiresAt    time.Time `json:"expires"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+}
+
+// SessionOptions set the lifetime of sessions.
+type SessionOptions struct {
+	// TTL is the lifetime of an access token.
+	TTL time.Duration
+	// RefreshTTL is the lifetime of a session with refresh tokens; 0 issues
+	// none.
+	RefreshTTL time.Duration
+	// Sliding renews the access token on use, up to the end of its
+	// session.
+	Sliding bool
+	// CacheTTL caps how long a validated session is kept in Redis; 0
+	// disables the cache.
+	CacheTTL time.Duration
+	// Signer, if set, makes access tokens signed JWTs validated without
+	// Postgres. Sessions are still stored, under the token id, for refresh
+	// and listing. Sliding expiry does not apply to them.
+	Signer *util.Signer
+	// RevocationCacheTTL is how long a signed token found not revoked is
+	// trusted before Redis is asked again, and so how late a sign-out on
+	// another instance may take effect; 0 asks on every request.
+	RevocationCacheTTL time.Duration
 }
 
 type userService struct {
 	repo repository.UserRepository
+	keys repository.APIKeyRepository
+	opts SessionOptions
+	// cache holds validated sessions for at most opts.CacheTTL, and never
+	// past their expiry.
+	cache *redis.Client
+
+	// revoked holds the ids of signed tokens signed out before their
+	// expiry, with that expiry. valid holds ids found not revoked, with
+	// the time until which that is trusted.
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+	valid   map[string]time.Time
 }
 
-func NewUserService(repo repository.UserRepository) UserService {
-	return &userService{repo: repo}
+func NewUserService(repo repository.UserRepository, keys repository.APIKeyRepository, cache *redis.Client, opts SessionOptions) UserService {
+	return &userService{repo: repo, keys: keys, cache: cache, opts: opts,
+		revoked: make(map[string]time.Time), valid: make(map[string]time.Time)}
+}
+
+// sessionKey keys cached sessions by a hash of the token, so the tokens
+// themselves never reach Redis.
+func sessionKey(token string) string {
+	return "session:" + util.HashToken(token)
+}
+
+// revokedKey marks a revoked signed token in Redis until it expires.
+func revokedKey(id string) string {
+	return "revoked:" + id
 }
 
 var loginRe = regexp.MustCompile(`^[A-Za-z0-9]{8,}$`)
@@ -60,31 +151,294 @@ func (s *userService) Register(ctx context.Context,
 }
 
 func (s *userService) Auth(ctx context.Context,
-	login, password string, ttl time.Duration) (string, error) {
+	login, password string, client models.Session) (*Tokens, error) {
 
 	user, err := s.repo.GetByLogin(ctx, login)
 	if err != nil {
-		return "", errors.New("invalid credentials")
+		return nil, errors.New("invalid credentials")
 	}
 	if err := util.CheckPassword(user.PasswordHash, password); err != nil {
-		return "", errors.New("invalid credentials")
+		return nil, errors.New("invalid credentials")
 	}
 
-	if token, err := s.repo.GetActiveToken(ctx, user.ID); err == nil && token != "" {
-		return token, nil
+	id := uuid.NewString()
+	t := &Tokens{}
+	client.ExpiresAt = time.Now().Add(s.opts.TTL)
+	var refreshHash string
+	if s.opts.RefreshTTL > 0 {
+		if t.RefreshToken, err = util.NewToken(); err != nil {
+			return nil, err
+		}
+		refreshHash = util.HashToken(t.RefreshToken)
+		end := time.Now().Add(s.opts.RefreshTTL)
+		client.RefreshExpiresAt = &end
+		client.ExpiresAt = minTime(client.ExpiresAt, end)
 	}
-	token := uuid.NewString()
-	expires := time.Now().Add(ttl)
-	if err := s.repo.CreateSession(ctx, token, user.ID, expires); err != nil {
-		return "", err
+	if err := s.repo.CreateSession(ctx, id, refreshHash, user.ID, client); err != nil {
+		return nil, err
+	}
+	t.ExpiresAt = client.ExpiresAt
+	if t.Token, err = s.issue(login, id, t.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// issue returns the access token handed out for the session stored under
+// id: id itself, or a JWT carrying it.
+func (s *userService) issue(login, id string, expires time.Time) (string, error) {
+	if s.opts.Signer == nil {
+		return id, nil
 	}
-	return token, nil
+	return s.opts.Signer.Sign(util.Claims{
+		Subject:   login,
+		ID:        id,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: expires.Unix(),
+	})
 }
 
+// sessionToken maps an access token to the token its session is stored
+// under.
+func (s *userService) sessionToken(token string) string {
+	if s.opts.Signer == nil {
+		return token
+	}
+	c, err := s.opts.Signer.Verify(token)
+	if err != nil {
+		return ""
+	}
+	return c.ID
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func (s *userService) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+	next, err := util.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	id := uuid.NewString()
+	t := &Tokens{RefreshToken: next}
+	old, login, expires, err := s.repo.RotateRefreshToken(ctx, util.HashToken(refreshToken),
+		id, util.HashToken(next), time.Now().Add(s.opts.TTL))
+	switch {
+	case errors.Is(err, repository.ErrRefreshReused):
+		_ = s.evict(ctx, old)
+		return nil, ErrRefreshTokenReused
+	case err != nil && (err.Error() == "not found" || err.Error() == "session expired"):
+		return nil, ErrInvalidRefreshToken
+	case err != nil:
+		return nil, err
+	}
+	// The replaced token runs out within TTL even if this fails.
+	_ = s.evict(ctx, old)
+	t.ExpiresAt = expires
+	if t.Token, err = s.issue(login, id, expires); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ValidateToken checks signed tokens locally. Session tokens are looked up
+// in Redis first; Redis errors other than a miss are ignored and the
+// session is read from Postgres.
 func (s *userService) ValidateToken(ctx context.Context, token string) (string, error) {
-	return s.repo.GetLoginByToken(ctx, token)
+	if token == "" {
+		return "", errors.New("not authorized")
+	}
+	if s.opts.Signer != nil {
+		c, err := s.opts.Signer.Verify(token)
+		if err != nil {
+			return "", err
+		}
+		revoked, err := s.isRevoked(ctx, c)
+		if err != nil {
+			return "", err
+		}
+		if revoked {
+			return "", errors.New("token revoked")
+		}
+		return c.Subject, nil
+	}
+	caching := s.cache != nil && s.opts.CacheTTL > 0
+	if caching {
+		if login, err := s.cache.Get(ctx, sessionKey(token)).Result(); err == nil {
+			return login, nil
+		}
+	}
+	login, expires, err := s.repo.GetLoginByToken(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	// Sliding sessions are renewed once half their lifetime has passed, so
+	// not every request writes to Postgres.
+	if s.opts.Sliding && time.Until(expires) < s.opts.TTL/2 {
+		if renewed, err := s.repo.ExtendSession(ctx, token, time.Now().Add(s.opts.TTL)); err == nil {
+			expires = renewed
+		}
+	}
+	if caching {
+		if ttl := min(s.opts.CacheTTL, time.Until(expires)); ttl > 0 {
+			_ = s.cache.Set(ctx, sessionKey(token), login, ttl).Err()
+		}
+	}
+	return login, nil
+}
+
+// isRevoked checks the local list first, then Redis, and Postgres if Redis
+// cannot be reached. A token found not revoked is not asked about again
+// for opts.RevocationCacheTTL.
+func (s *userService) isRevoked(ctx context.Context, c *util.Claims) (bool, error) {
+	now := time.Now()
+	s.mu.RLock()
+	_, revoked := s.revoked[c.ID]
+	until, checked := s.valid[c.ID]
+	s.mu.RUnlock()
+	if revoked {
+		return true, nil
+	}
+	if checked && now.Before(until) {
+		return false, nil
+	}
+
+	var err error
+	if s.cache != nil {
+		var n int64
+		if n, err = s.cache.Exists(ctx, revokedKey(c.ID)).Result(); err == nil {
+			revoked = n > 0
+		}
+	}
+	if s.cache == nil || err != nil {
+		if revoked, err = s.repo.IsTokenRevoked(ctx, c.ID); err != nil {
+			return false, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if revoked {
+		s.revoked[c.ID] = time.Unix(c.ExpiresAt, 0)
+	} else if s.opts.RevocationCacheTTL > 0 {
+		s.valid[c.ID] = now.Add(s.opts.RevocationCacheTTL)
+	}
+	return revoked, nil
 }
 
 func (s *userService) Logout(ctx context.Context, token string) error {
-	return s.repo.DeleteSession(ctx, token)
+	token = s.sessionToken(token)
+	if token == "" {
+		return errors.New("not found")
+	}
+	// The row goes first: evicting before it is gone would let a
+	// concurrent ValidateToken load the session back into the cache.
+	if err := s.repo.DeleteSession(ctx, token); err != nil {
+		return err
+	}
+	return s.evict(ctx, token)
+}
+
+// evict makes the access tokens of ended sessions stop working at once:
+// session tokens are dropped from the cache, signed tokens revoked.
+func (s *userService) evict(ctx context.Context, tokens ...string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	if s.opts.Signer != nil {
+		// No signed token outlives its session's TTL from now.
+		expires := time.Now().Add(s.opts.TTL)
+		s.mu.Lock()
+		for _, t := range tokens {
+			s.revoked[t] = expires
+			delete(s.valid, t)
+		}
+		s.mu.Unlock()
+		if err := s.repo.RevokeTokens(ctx, tokens, expires); err != nil {
+			return err
+		}
+		// Postgres stays the record; Redis is what other instances ask on
+		// each request, and SyncRevocations catches anything it missed.
+		if s.cache != nil {
+			pipe := s.cache.Pipeline()
+			for _, t := range tokens {
+				pipe.Set(ctx, revokedKey(t), 1, time.Until(expires))
+			}
+			_, _ = pipe.Exec(ctx)
+		}
+		return nil
+	}
+	if s.cache == nil {
+		return nil
+	}
+	keys := make([]string, len(tokens))
+	for i, t := range tokens {
+		keys[i] = sessionKey(t)
+	}
+	_ = s.cache.Del(ctx, keys...).Err()
+	return nil
+}
+
+func (s *userService) SyncRevocations(ctx context.Context) error {
+	if s.opts.Signer == nil {
+		return nil
+	}
+	revoked, err := s.repo.ListRevokedTokens(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, until := range s.valid {
+		if now.After(until) {
+			delete(s.valid, id)
+		}
+	}
+	// Keep local revocations the store has not caught up with yet.
+	for id, expires := range s.revoked {
+		if _, ok := revoked[id]; !ok && now.Before(expires) {
+			revoked[id] = expires
+		}
+	}
+	s.revoked = revoked
+	return nil
+}
+
+func (s *userService) ListSessions(ctx context.Context, login, current string) ([]models.Session, error) {
+	sessions, tokens, err := s.repo.ListSessions(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+	current = s.sessionToken(current)
+	for i := range sessions {
+		sessions[i].Current = tokens[i] == current
+	}
+	return sessions, nil
+}
+
+func (s *userService) RevokeSession(ctx context.Context, login, id string) error {
+	token, err := s.repo.DeleteSessionByID(ctx, login, id)
+	if err != nil {
+		return err
+	}
+	return s.evict(ctx, token)
+}
+
+func (s *userService) RevokeOtherSessions(ctx context.Context, login, current string) (int, error) {
+	tokens, err := s.repo.DeleteOtherSessions(ctx, login, s.sessionToken(current))
+	if err != nil {
+		return 0, err
+	}
+	if err := s.evict(ctx, tokens...); err != nil {
+		return 0, err
+	}
+	return len(tokens), nil
 }
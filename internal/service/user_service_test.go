@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"web-server/internal/repository"
+	"web-server/internal/util"
+)
+
+// revocationRepo answers revocation lookups from a set and counts them.
+type revocationRepo struct {
+	repository.UserRepository
+	revoked map[string]bool
+	lookups int
+}
+
+func (r *revocationRepo) IsTokenRevoked(ctx context.Context, id string) (bool, error) {
+	r.lookups++
+	return r.revoked[id], nil
+}
+
+func TestIsRevoked(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name        string
+		cacheTTL    time.Duration
+		revoked     bool
+		wantLookups int
+	}{
+		{"unrevoked, cached", time.Minute, false, 1},
+		{"unrevoked, uncached", 0, false, 3},
+		{"revoked elsewhere", time.Minute, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &revocationRepo{revoked: map[string]bool{"jti": tt.revoked}}
+			s := NewUserService(repo, nil, nil, SessionOptions{RevocationCacheTTL: tt.cacheTTL}).(*userService)
+			c := &util.Claims{ID: "jti", ExpiresAt: exp}
+			for range 3 {
+				got, err := s.isRevoked(context.Background(), c)
+				if err != nil || got != tt.revoked {
+					t.Fatalf("isRevoked = %v, %v; want %v", got, err, tt.revoked)
+				}
+			}
+			if repo.lookups != tt.wantLookups {
+				t.Errorf("%d lookups, want %d", repo.lookups, tt.wantLookups)
+			}
+		})
+	}
+}
+
+// sessionRepo keeps the sessions of alice keyed by refresh token hash,
+// with the rotated-out hashes of each, as the sessions table does.
+type sessionRepo struct {
+	revocationRepo
+	sessions map[string]string // refresh hash -> access token id
+	used     map[string]string // rotated-out refresh hash -> its session's current hash
+}
+
+func (r *sessionRepo) RotateRefreshToken(ctx context.Context, refreshHash, newToken, newRefreshHash string, expires time.Time) (string, string, time.Time, error) {
+	old, ok := r.sessions[refreshHash]
+	if !ok {
+		current, reused := r.used[refreshHash]
+		if !reused {
+			return "", "", time.Time{}, errors.New("not found")
+		}
+		old = r.sessions[current]
+		delete(r.sessions, current)
+		return old, "", time.Time{}, repository.ErrRefreshReused
+	}
+	delete(r.sessions, refreshHash)
+	r.sessions[newRefreshHash] = newToken
+	for h, current := range r.used {
+		if current == refreshHash {
+			r.used[h] = newRefreshHash
+		}
+	}
+	r.used[refreshHash] = newRefreshHash
+	return old, "alice", expires, nil
+}
+
+func (r *sessionRepo) RevokeTokens(ctx context.Context, ids []string, expires time.Time) error {
+	for _, id := range ids {
+		r.revoked[id] = true
+	}
+	return nil
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	key, err := util.ParseSigningKey("k1", "HS256", base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := util.NewSigner([]util.SigningKey{key}, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	repo := &sessionRepo{
+		revocationRepo: revocationRepo{revoked: map[string]bool{}},
+		sessions:       map[string]string{util.HashToken("r0"): "s0"},
+		used:           map[string]string{},
+	}
+	s := NewUserService(repo, nil, nil, SessionOptions{TTL: time.Hour, Signer: signer}).(*userService)
+	first, err := s.issue("alice", "s0", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := s.Refresh(ctx, "r0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateToken(ctx, first); err == nil {
+		t.Error("access token replaced by refresh still valid")
+	}
+	if login, err := s.ValidateToken(ctx, second.Token); login != "alice" || err != nil {
+		t.Fatalf("refreshed token: %q, %v", login, err)
+	}
+
+	// r0 was rotated out: presenting it again ends the whole session.
+	if _, err := s.Refresh(ctx, "r0"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reuse: %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := s.ValidateToken(ctx, second.Token); err == nil {
+		t.Error("access token of the revoked session still valid")
+	}
+	if _, err := s.Refresh(ctx, second.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("current refresh token: %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := s.Refresh(ctx, "unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("unknown refresh token: %v, want ErrInvalidRefreshToken", err)
+	}
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	s := &localStore{dir: "/data"}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"abc", "/data/abc"},
+		{"tus/id/0001-x", "/data/tus/id/0001-x"},
+		{"a/../b", "/data/b"},
+		{"./a", "/data/a"},
+		{"a/", "/data/a"},
+		{"..a", "/data/..a"},
+		{"", ""},
+		{".", ""},
+		{"..", ""},
+		{"../x", ""},
+		{"a/../../x", ""},
+		{"/etc/passwd", ""},
+	}
+	for _, tt := range tests {
+		got, err := s.path(tt.key)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("path(%q) = %q, want error", tt.key, got)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("path(%q) = %q, %v; want %q", tt.key, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// rangeReader turns GetRange into an io.ReadSeeker, so http.ServeContent
+// can answer Range requests from any backend. The blob is only opened on
+// the first Read after a Seek, one request per contiguous range.
+type rangeReader struct {
+	ctx   context.Context
+	store BlobStore
+	key   string
+	size  int64
+	off   int64
+	rc    io.ReadCloser
+}
+
+func NewReader(ctx context.Context, store BlobStore, key string, size int64) io.ReadSeekCloser {
+	return &rangeReader{ctx: ctx, store: store, key: key, size: size}
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.rc == nil {
+		rc, err := r.store.GetRange(r.ctx, r.key, r.off, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
+	}
+	n, err := r.rc.Read(p)
+	r.off += int64(n)
+	return n, err
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	var off int64
+	switch whence {
+	case io.SeekStart:
+		off = offset
+	case io.SeekCurrent:
+		off = r.off + offset
+	case io.SeekEnd:
+		off = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if off < 0 {
+		return 0, errors.New("negative position")
+	}
+	if off != r.off && r.rc != nil {
+		r.rc.Close()
+		r.rc = nil
+	}
+	r.off = off
+	return off, nil
+}
+
+func (r *rangeReader) Close() error {
+	if r.rc != nil {
+		return r.rc.Close()
+	}
+	return nil
+}
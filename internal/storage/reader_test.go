@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// countingStore serves blobs from memory and counts GetRange calls. Only
+// GetRange is implemented; rangeReader needs nothing else.
+type countingStore struct {
+	BlobStore
+	blobs map[string]string
+	opens int
+}
+
+func (s *countingStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	s.opens++
+	b, ok := s.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	b = b[min(offset, int64(len(b))):]
+	if length >= 0 && length < int64(len(b)) {
+		b = b[:length]
+	}
+	return io.NopCloser(strings.NewReader(b)), nil
+}
+
+func TestRangeReader(t *testing.T) {
+	const content = "0123456789"
+	ctx := context.Background()
+
+	type seek struct {
+		offset int64
+		whence int
+	}
+	tests := []struct {
+		name      string
+		seeks     []seek
+		read      int
+		want      string
+		wantPos   int64
+		wantOpens int
+	}{
+		{"whole", nil, 20, content, 10, 1},
+		{"from start", []seek{{3, io.SeekStart}}, 4, "3456", 7, 1},
+		{"from current", []seek{{2, io.SeekStart}, {3, io.SeekCurrent}}, 2, "56", 7, 1},
+		{"from end", []seek{{-3, io.SeekEnd}}, 20, "789", 10, 1},
+		{"size probe", []seek{{0, io.SeekEnd}, {0, io.SeekStart}}, 2, "01", 2, 1},
+		{"at end", []seek{{0, io.SeekEnd}}, 5, "", 10, 0},
+		{"past end", []seek{{20, io.SeekStart}}, 5, "", 20, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &countingStore{blobs: map[string]string{"k": content}}
+			r := NewReader(ctx, store, "k", int64(len(content)))
+			defer r.Close()
+			for _, s := range tt.seeks {
+				if _, err := r.Seek(s.offset, s.whence); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := io.ReadAll(io.LimitReader(r, int64(tt.read)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+			if pos, _ := r.Seek(0, io.SeekCurrent); pos != tt.wantPos {
+				t.Errorf("position %d, want %d", pos, tt.wantPos)
+			}
+			if store.opens != tt.wantOpens {
+				t.Errorf("%d GetRange calls, want %d", store.opens, tt.wantOpens)
+			}
+		})
+	}
+}
+
+func TestRangeReaderReopensAfterSeek(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{blobs: map[string]string{"k": "abcdef"}}
+	r := NewReader(ctx, store, "k", 6)
+	defer r.Close()
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "ab" {
+		t.Fatalf("first read %q, %v", buf, err)
+	}
+	// Seeking to where the reader already is keeps the open stream.
+	if _, err := r.Seek(2, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "cd" {
+		t.Fatalf("second read %q, %v", buf, err)
+	}
+	if store.opens != 1 {
+		t.Fatalf("%d GetRange calls after no-op seek, want 1", store.opens)
+	}
+	if _, err := r.Seek(1, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "bc" {
+		t.Fatalf("read after seek %q, %v", buf, err)
+	}
+	if store.opens != 2 {
+		t.Fatalf("%d GetRange calls after seek, want 2", store.opens)
+	}
+
+	for _, s := range []struct {
+		offset int64
+		whence int
+	}{{-1, io.SeekStart}, {-10, io.SeekEnd}, {0, 42}} {
+		if _, err := r.Seek(s.offset, s.whence); err == nil {
+			t.Errorf("Seek(%d, %d) succeeded", s.offset, s.whence)
+		}
+	}
+}
+
+func TestRangeReaderMissingBlob(t *testing.T) {
+	r := NewReader(context.Background(), &countingStore{}, "missing", 3)
+	defer r.Close()
+	if _, err := r.Read(make([]byte, 1)); err != ErrNotFound {
+		t.Fatalf("Read = %v, want ErrNotFound", err)
+	}
+}
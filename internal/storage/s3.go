@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"web-server/internal/config"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const defaultPartSize = 16 << 20
+
+type s3Store struct {
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	partSize uint64
+}
+
+// NewS3 connects to an S3-compatible endpoint (AWS, MinIO, ...) and makes
+// sure the bucket exists.
+func NewS3(ctx context.Context, cfg config.S3Cfg) (BlobStore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket required")
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ok, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, err
+		}
+	}
+	partSize := uint64(cfg.PartSizeMB) << 20
+	if partSize == 0 {
+		partSize = defaultPartSize
+	}
+	return &s3Store{
+		client:   client,
+		bucket:   cfg.Bucket,
+		prefix:   strings.Trim(cfg.Prefix, "/"),
+		partSize: partSize,
+	}, nil
+}
+
+func (s *s3Store) object(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+func (s *s3Store) key(object string) string {
+	if s.prefix == "" {
+		return object
+	}
+	return strings.TrimPrefix(object, s.prefix+"/")
+}
+
+func isNoSuchKey(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+// Put streams r to the bucket. The size is unknown up front, so the client
+// splits the body into partSize chunks and uses a multipart upload.
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	info, err := s.client.PutObject(ctx, s.bucket, s.object(key), r, -1, minio.PutObjectOptions{
+		PartSize: s.partSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+func (s *s3Store) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	var err error
+	switch {
+	case length > 0:
+		err = opts.SetRange(offset, offset+length-1)
+	case length == 0:
+		return io.NopCloser(strings.NewReader("")), nil
+	case offset > 0:
+		err = opts.SetRange(offset, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, s.object(key), opts)
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy; stat forces the request so a missing key is
+	// reported here rather than on the first Read.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if isNoSuchKey(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *s3Store) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.object(key), minio.StatObjectOptions{})
+	if err != nil {
+		if isNoSuchKey(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &BlobInfo{Key: key, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	if _, err := s.Stat(ctx, key); err != nil {
+		return err
+	}
+	return s.client.RemoveObject(ctx, s.bucket, s.object(key), minio.RemoveObjectOptions{})
+}
+
+// Move copies src to dst server-side and removes src. ComposeObject is used
+// instead of CopyObject because it also handles objects over 5GB.
+func (s *s3Store) Move(ctx context.Context, src, dst string) error {
+	_, err := s.client.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: s.object(dst)},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: s.object(src)})
+	if err != nil {
+		if isNoSuchKey(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return s.client.RemoveObject(ctx, s.bucket, s.object(src), minio.RemoveObjectOptions{})
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	var out []BlobInfo
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.object(prefix),
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		out = append(out, BlobInfo{Key: s.key(obj.Key), Size: obj.Size, ModTime: obj.LastModified})
+	}
+	return out, nil
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"web-server/internal/config"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStore keeps the contents of file documents.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange reads length bytes starting at offset; a negative length
+	// reads to the end of the blob.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+	// Move renames a blob, replacing dst if it already exists.
+	Move(ctx context.Context, src, dst string) error
+	List(ctx context.Context, prefix string) ([]BlobInfo, error)
+}
+
+func New(cfg *config.Config) (BlobStore, error) {
+	switch cfg.Storage.Driver {
+	case "", "local":
+		dir := cfg.Storage.Dir
+		if dir == "" {
+			dir = "uploads"
+		}
+		return NewLocal(dir)
+	case "s3":
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return NewS3(ctx, cfg.Storage.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Storage.Driver)
+	}
+}
@@ -0,0 +1,176 @@
+package util
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Algorithms supported for signed tokens.
+const (
+	AlgEdDSA = "EdDSA"
+	AlgHS256 = "HS256"
+)
+
+var ErrInvalidSignedToken = errors.New("invalid signed token")
+
+// SigningKey is one key of a signed token key set, told apart by KID. Keys
+// without private material (Secret or Private) only verify.
+type SigningKey struct {
+	KID     string
+	Alg     string
+	Secret  []byte
+	Private ed25519.PrivateKey
+	Public  ed25519.PublicKey
+}
+
+// ParseSigningKey builds a key from its config form: for HS256 key is the
+// base64 secret, for EdDSA the base64 32-byte seed. An EdDSA key may
+// instead give only publicKey, to verify tokens signed elsewhere.
+func ParseSigningKey(kid, alg, key, publicKey string) (SigningKey, error) {
+	k := SigningKey{KID: kid, Alg: alg}
+	if kid == "" {
+		return k, errors.New("signing key without kid")
+	}
+	switch alg {
+	case AlgHS256:
+		secret, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(secret) < 32 {
+			return k, errors.New("signing key " + kid + ": need a base64 secret of at least 32 bytes")
+		}
+		k.Secret = secret
+	case AlgEdDSA:
+		if key != "" {
+			seed, err := base64.StdEncoding.DecodeString(key)
+			if err != nil || len(seed) != ed25519.SeedSize {
+				return k, errors.New("signing key " + kid + ": need a base64 32-byte seed")
+			}
+			k.Private = ed25519.NewKeyFromSeed(seed)
+			k.Public = k.Private.Public().(ed25519.PublicKey)
+		} else {
+			pub, err := base64.StdEncoding.DecodeString(publicKey)
+			if err != nil || len(pub) != ed25519.PublicKeySize {
+				return k, errors.New("signing key " + kid + ": need a base64 seed or public key")
+			}
+			k.Public = pub
+		}
+	default:
+		return k, errors.New("signing key " + kid + ": unknown alg " + alg)
+	}
+	return k, nil
+}
+
+func (k SigningKey) canSign() bool {
+	return k.Secret != nil || k.Private != nil
+}
+
+// Claims are the payload of a signed token, in JWT claim names.
+type Claims struct {
+	Subject   string `json:"sub"`
+	ID        string `json:"jti"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	KID string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// Signer issues and verifies compact JWS tokens (JWTs). It signs with the
+// active key and verifies with any key of the set, so keys can be rotated
+// by adding a new key, making it active and dropping the old one once its
+// tokens have expired.
+type Signer struct {
+	keys   map[string]SigningKey
+	active string
+}
+
+func NewSigner(keys []SigningKey, active string) (*Signer, error) {
+	s := &Signer{keys: make(map[string]SigningKey, len(keys)), active: active}
+	for _, k := range keys {
+		s.keys[k.KID] = k
+	}
+	if k, ok := s.keys[active]; !ok || !k.canSign() {
+		return nil, errors.New("active signing key " + active + " missing or verify-only")
+	}
+	return s, nil
+}
+
+var b64 = base64.RawURLEncoding
+
+func (s *Signer) Sign(c Claims) (string, error) {
+	k := s.keys[s.active]
+	header, err := json.Marshal(tokenHeader{Alg: k.Alg, KID: k.KID, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	return signed + "." + b64.EncodeToString(sign(k, signed)), nil
+}
+
+func sign(k SigningKey, signed string) []byte {
+	if k.Alg == AlgHS256 {
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write([]byte(signed))
+		return mac.Sum(nil)
+	}
+	return ed25519.Sign(k.Private, []byte(signed))
+}
+
+// Verify checks the signature and expiry of token and returns its claims.
+func (s *Signer) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidSignedToken
+	}
+	raw, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidSignedToken
+	}
+	var h tokenHeader
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return nil, ErrInvalidSignedToken
+	}
+	k, ok := s.keys[h.KID]
+	// The algorithm is fixed by the key, never taken from the token.
+	if !ok || h.Alg != k.Alg {
+		return nil, ErrInvalidSignedToken
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidSignedToken
+	}
+	signed := parts[0] + "." + parts[1]
+	switch k.Alg {
+	case AlgHS256:
+		ok = k.Secret != nil && hmac.Equal(sig, sign(k, signed))
+	case AlgEdDSA:
+		ok = ed25519.Verify(k.Public, []byte(signed), sig)
+	}
+	if !ok {
+		return nil, ErrInvalidSignedToken
+	}
+	raw, err = b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidSignedToken
+	}
+	var c Claims
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, ErrInvalidSignedToken
+	}
+	if c.Subject == "" || time.Now().Unix() >= c.ExpiresAt {
+		return nil, ErrInvalidSignedToken
+	}
+	return &c, nil
+}
@@ -0,0 +1,141 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T, kid, alg, key, publicKey string) SigningKey {
+	t.Helper()
+	k, err := ParseSigningKey(kid, alg, key, publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func testSigner(t *testing.T, active string, keys ...SigningKey) *Signer {
+	t.Helper()
+	s, err := NewSigner(keys, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// forge builds a token with the given header and claims, signed by k.
+func forge(header tokenHeader, c Claims, k SigningKey) string {
+	h, _ := json.Marshal(header)
+	p, _ := json.Marshal(c)
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(p)
+	return signed + "." + b64.EncodeToString(sign(k, signed))
+}
+
+func TestSignerVerify(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("s", 32)))
+	other := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))
+	seed := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("e", 32)))
+
+	hs := testKey(t, "hs", AlgHS256, secret, "")
+	ed := testKey(t, "ed", AlgEdDSA, seed, "")
+	edPublic := base64.StdEncoding.EncodeToString(ed.Public)
+	// A key with the same kid but another secret stands in for an attacker.
+	forged := testKey(t, "hs", AlgHS256, other, "")
+
+	now := time.Now().Unix()
+	valid := Claims{Subject: "alice", ID: "j1", IssuedAt: now, ExpiresAt: now + 60}
+	expired := Claims{Subject: "alice", ID: "j1", IssuedAt: now - 120, ExpiresAt: now - 60}
+
+	hsSigner := testSigner(t, "hs", hs, ed)
+	edSigner := testSigner(t, "ed", hs, ed)
+	hsToken, err := hsSigner.Sign(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edToken, err := edSigner.Sign(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(hsToken, ".")
+	sig := []byte(parts[2])
+	sig[0] ^= 1
+	tamperedSig := parts[0] + "." + parts[1] + "." + string(sig)
+	otherPayload, _ := json.Marshal(Claims{Subject: "mallory", ExpiresAt: now + 60})
+	tamperedPayload := parts[0] + "." + b64.EncodeToString(otherPayload) + "." + parts[2]
+
+	tests := []struct {
+		name   string
+		signer *Signer
+		token  string
+		ok     bool
+	}{
+		{"hs256", hsSigner, hsToken, true},
+		{"eddsa", hsSigner, edToken, true},
+		{"rotated key still verifies", edSigner, hsToken, true},
+		{"verify-only eddsa key", testSigner(t, "hs", hs, testKey(t, "ed", AlgEdDSA, "", edPublic)), edToken, true},
+		{"expired", hsSigner, forge(tokenHeader{Alg: AlgHS256, KID: "hs"}, expired, hs), false},
+		{"no subject", hsSigner, forge(tokenHeader{Alg: AlgHS256, KID: "hs"}, Claims{ExpiresAt: now + 60}, hs), false},
+		{"unknown kid", hsSigner, forge(tokenHeader{Alg: AlgHS256, KID: "gone"}, valid, hs), false},
+		{"dropped key", testSigner(t, "ed", ed), hsToken, false},
+		{"alg mismatch", hsSigner, forge(tokenHeader{Alg: AlgEdDSA, KID: "hs"}, valid, hs), false},
+		{"alg none", hsSigner, forge(tokenHeader{Alg: "none", KID: "hs"}, valid, hs), false},
+		{"wrong secret", hsSigner, forge(tokenHeader{Alg: AlgHS256, KID: "hs"}, valid, forged), false},
+		{"tampered signature", hsSigner, tamperedSig, false},
+		{"tampered payload", hsSigner, tamperedPayload, false},
+		{"unsigned", hsSigner, parts[0] + "." + parts[1] + ".", false},
+		{"two parts", hsSigner, parts[0] + "." + parts[1], false},
+		{"garbage", hsSigner, "a.b.c", false},
+		{"empty", hsSigner, "", false},
+	}
+	for _, tt := range tests {
+		c, err := tt.signer.Verify(tt.token)
+		if !tt.ok {
+			if err != ErrInvalidSignedToken {
+				t.Errorf("%s: Verify = %v, %v; want ErrInvalidSignedToken", tt.name, c, err)
+			}
+			continue
+		}
+		if err != nil || *c != valid {
+			t.Errorf("%s: Verify = %v, %v; want %v", tt.name, c, err, valid)
+		}
+	}
+}
+
+func TestNewSignerNeedsSigningKey(t *testing.T) {
+	seed := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("e", 32)))
+	ed := testKey(t, "ed", AlgEdDSA, seed, "")
+	pub := testKey(t, "pub", AlgEdDSA, "", base64.StdEncoding.EncodeToString(ed.Public))
+	if _, err := NewSigner([]SigningKey{ed, pub}, "pub"); err == nil {
+		t.Error("verify-only active key accepted")
+	}
+	if _, err := NewSigner([]SigningKey{ed}, "missing"); err == nil {
+		t.Error("missing active key accepted")
+	}
+}
+
+func TestParseSigningKey(t *testing.T) {
+	tests := []struct {
+		name               string
+		kid, alg, key, pub string
+		ok                 bool
+	}{
+		{"hs256", "k", AlgHS256, base64.StdEncoding.EncodeToString(make([]byte, 32)), "", true},
+		{"hs256 short", "k", AlgHS256, base64.StdEncoding.EncodeToString(make([]byte, 31)), "", false},
+		{"hs256 not base64", "k", AlgHS256, "!!", "", false},
+		{"eddsa seed", "k", AlgEdDSA, base64.StdEncoding.EncodeToString(make([]byte, 32)), "", true},
+		{"eddsa bad seed", "k", AlgEdDSA, base64.StdEncoding.EncodeToString(make([]byte, 16)), "", false},
+		{"eddsa public", "k", AlgEdDSA, "", base64.StdEncoding.EncodeToString(make([]byte, 32)), true},
+		{"eddsa nothing", "k", AlgEdDSA, "", "", false},
+		{"no kid", "", AlgHS256, base64.StdEncoding.EncodeToString(make([]byte, 32)), "", false},
+		{"unknown alg", "k", "RS256", "x", "", false},
+	}
+	for _, tt := range tests {
+		_, err := ParseSigningKey(tt.kid, tt.alg, tt.key, tt.pub)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS uploads (
+  id TEXT PRIMARY KEY,
+  owner TEXT NOT NULL,
+  length BIGINT NOT NULL,
+  "offset" BIGINT NOT NULL DEFAULT 0,
+  meta JSONB NOT NULL,
+  json JSONB,
+  parts JSONB NOT NULL DEFAULT '[]',
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_uploads_expires_at ON uploads(expires_at);
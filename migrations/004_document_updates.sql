@@ -0,0 +1,5 @@
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE documents SET updated_at = created_at WHERE updated_at IS NULL;
+
+ALTER TABLE documents ALTER COLUMN updated_at SET DEFAULT now();
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS document_revisions (
+  document_id TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+  version BIGINT NOT NULL,
+  name TEXT,
+  mime TEXT,
+  file BOOLEAN DEFAULT false,
+  json JSONB,
+  filename TEXT,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  archived_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  PRIMARY KEY (document_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_revisions_filename ON document_revisions(filename);
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS max_revisions INTEGER;
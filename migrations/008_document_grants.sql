@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS document_grants (
+  document_id TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  PRIMARY KEY (document_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_grants_user_id ON document_grants(user_id);
+
+-- Grants used to be a JSONB array of logins; logins without an account
+-- could never sign in and are dropped.
+INSERT INTO document_grants (document_id, user_id)
+SELECT d.id, u.id
+FROM documents d
+CROSS JOIN LATERAL jsonb_array_elements_text(
+  CASE WHEN jsonb_typeof(d.grants) = 'array' THEN d.grants ELSE '[]'::jsonb END
+) AS g(login)
+JOIN users u ON u.login = g.login
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE documents DROP COLUMN IF EXISTS grants;
+
+CREATE INDEX IF NOT EXISTS idx_documents_public ON documents(public) WHERE public;
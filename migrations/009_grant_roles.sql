@@ -0,0 +1,3 @@
+ALTER TABLE document_grants
+  ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'viewer'
+  CHECK (role IN ('viewer', 'editor', 'manager'));
@@ -0,0 +1,14 @@
+-- Audit log of ownership transfers. Rows outlive the document so the trail
+-- survives a purge.
+CREATE TABLE IF NOT EXISTS document_transfers (
+  id BIGSERIAL PRIMARY KEY,
+  document_id TEXT NOT NULL,
+  from_owner TEXT NOT NULL,
+  to_owner TEXT NOT NULL,
+  actor TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_transfers_document_id ON document_transfers(document_id);
+CREATE INDEX IF NOT EXISTS idx_document_transfers_from_owner ON document_transfers(from_owner);
+CREATE INDEX IF NOT EXISTS idx_document_transfers_to_owner ON document_transfers(to_owner);
@@ -0,0 +1,8 @@
+-- Every sign-in gets its own session; id lets users refer to a session
+-- without knowing its token.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS id UUID NOT NULL DEFAULT gen_random_uuid();
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_id ON sessions(id);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
@@ -0,0 +1,16 @@
+-- A session is a refresh token family: refresh_hash is the current refresh
+-- token, rotated on every use, and the access token lives in token.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS refresh_hash TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS refresh_expires_at TIMESTAMP WITH TIME ZONE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_refresh_hash ON sessions(refresh_hash);
+
+-- Refresh tokens already rotated out. Presenting one again means it was
+-- stolen, and the whole session is revoked.
+CREATE TABLE IF NOT EXISTS used_refresh_tokens (
+  token_hash TEXT PRIMARY KEY,
+  session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+  used_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_used_refresh_tokens_session_id ON used_refresh_tokens(session_id);
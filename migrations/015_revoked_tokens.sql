@@ -0,0 +1,8 @@
+-- Signed access tokens cannot be unissued; ids of those signed out early
+-- are listed here until they would have expired anyway.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  jti TEXT PRIMARY KEY,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
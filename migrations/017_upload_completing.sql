@@ -0,0 +1,3 @@
+-- Set while one request assembles the finished upload into a document, so
+-- a concurrent PATCH cannot create it a second time.
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS completing BOOLEAN NOT NULL DEFAULT false;